@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// asnEntry is the autonomous system announcing a range of addresses
+type asnEntry struct {
+	asn string
+	org string
+}
+
+// asnDatabase maps addresses to autonomous systems. It is read from an
+// ip2asn file as published on iptoasn.com: tab separated lines of range
+// start, range end, AS number, country code and AS description, optionally
+// gzip compressed.
+type asnDatabase struct {
+	ranges rangeTable
+}
+
+var (
+	asnDB *asnDatabase
+	// asnMutex guards asnDB, which is replaced on reload
+	asnMutex sync.RWMutex
+)
+
+// currentASNDatabase returns the active ASN database, nil if none is configured
+func currentASNDatabase() *asnDatabase {
+	asnMutex.RLock()
+	defer asnMutex.RUnlock()
+	return asnDB
+}
+
+// setASNDatabase replaces the active ASN database
+func setASNDatabase(db *asnDatabase) {
+	asnMutex.Lock()
+	defer asnMutex.Unlock()
+	asnDB = db
+}
+
+// loadASNDatabase reads the ip2asn file, it returns nil if file is empty
+func loadASNDatabase(file string) (*asnDatabase, error) {
+	if file == "" {
+		return nil, nil
+	}
+	var db *asnDatabase
+	err := readDatabase(file, func(r io.Reader) (err error) {
+		db, err = parseASNDatabase(r)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading ASN database %s: %s", file, err)
+	}
+	return db, nil
+}
+
+// readDatabase calls parse with the contents of file, decompressed if the
+// name ends in .gz
+func readDatabase(file string, parse func(io.Reader) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return parse(r)
+}
+
+// addressRange is a range of addresses along with what a database knows
+// about them
+type addressRange struct {
+	// first and last are in 16 byte form, so IPv4 and IPv6 ranges sort together
+	first net.IP
+	last  net.IP
+	value interface{}
+}
+
+// rangeTable is the address ranges of a database, sorted by their first
+// address once all are added
+type rangeTable []addressRange
+
+// add parses the first and last address of a range and adds it with value
+func (t *rangeTable) add(first, last string, value interface{}) error {
+	from, to := net.ParseIP(first), net.ParseIP(last)
+	if from == nil || to == nil || bytes.Compare(from.To16(), to.To16()) > 0 {
+		return fmt.Errorf("invalid address range %s - %s", first, last)
+	}
+	*t = append(*t, addressRange{first: from.To16(), last: to.To16(), value: value})
+	return nil
+}
+
+// sort sorts the ranges by their first address
+func (t rangeTable) sort() {
+	sort.Slice(t, func(i, j int) bool {
+		return bytes.Compare(t[i].first, t[j].first) < 0
+	})
+}
+
+// lookup returns the value of the range containing the address, nil if there
+// is none
+func (t rangeTable) lookup(ip net.IP) interface{} {
+	ip = ip.To16()
+	if ip == nil {
+		return nil
+	}
+	// the last range starting at or before the address
+	i := sort.Search(len(t), func(i int) bool {
+		return bytes.Compare(t[i].first, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, t[i].last) > 0 {
+		return nil
+	}
+	return t[i].value
+}
+
+// parseASNDatabase parses ip2asn lines. Ranges of AS 0, which ip2asn uses for
+// unrouted addresses, are skipped.
+func parseASNDatabase(r io.Reader) (*asnDatabase, error) {
+	db := &asnDatabase{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 tab separated fields, got %d", line, len(fields))
+		}
+		asn, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid AS number %q", line, fields[2])
+		}
+		if asn == 0 {
+			continue
+		}
+		org := ""
+		if len(fields) > 4 {
+			org = strings.TrimSpace(fields[4])
+		}
+		entry := &asnEntry{asn: "AS" + strconv.FormatUint(asn, 10), org: org}
+		if err := db.ranges.add(fields[0], fields[1], entry); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	db.ranges.sort()
+	return db, nil
+}
+
+// size returns the number of address ranges of the database, 0 for nil
+func (db *asnDatabase) size() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.ranges)
+}
+
+// lookup returns the AS number and organisation of the address
+func (db *asnDatabase) lookup(ip net.IP) (string, string, bool) {
+	entry, ok := db.ranges.lookup(ip).(*asnEntry)
+	if !ok {
+		return "", "", false
+	}
+	return entry.asn, entry.org, true
+}
+
+// enrichASN sets the autonomous systems of all responders from the ASN
+// database. Hops keep the ASN reported by mtr if the database doesn't know
+// their address.
+func enrichASN(result *TraceResult) {
+	db := currentASNDatabase()
+	if db == nil || result == nil {
+		return
+	}
+	for _, hop := range result.Hops {
+		for _, r := range hop.Responders {
+			asn, org, ok := db.lookup(r.IP)
+			if !ok {
+				continue
+			}
+			r.ASN, r.ASOrg = asn, org
+			if r.IP.Equal(hop.IP) {
+				hop.ASN, hop.ASOrg = asn, org
+			}
+		}
+	}
+}
+
+// asPath returns the autonomous systems the trace passed through in order,
+// separated by spaces. Hops of unknown AS are skipped.
+func asPath(hops []*Hop) string {
+	var path []string
+	for _, hop := range hops {
+		if hop.ASN != "" && (len(path) == 0 || path[len(path)-1] != hop.ASN) {
+			path = append(path, hop.ASN)
+		}
+	}
+	return strings.Join(path, " ")
+}
+
+// recordASPath updates mtr_as_path_info and counts a change of the AS path.
+// Traces without any known AS leave the last path in place. The caller must
+// hold e.mutex.
+func (e *Exporter) recordASPath(labels []string, hops []*Hop) {
+	alias := labels[0]
+	path := asPath(hops)
+	if path == "" {
+		return
+	}
+	last := e.lastASPath[alias]
+	if last != path {
+		if last != "" {
+			e.series.remove(alias, append(labels, last), e.asPathInfo)
+			e.asPathChanges.WithLabelValues(labels...).Inc()
+			e.series.add(alias, labels, e.asPathChanges)
+		}
+		e.lastASPath[alias] = path
+	}
+	lvs := append(labels, path)
+	e.asPathInfo.WithLabelValues(lvs...).Set(1)
+	e.series.add(alias, lvs, e.asPathInfo)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestASNDatabaseLookup(t *testing.T) {
+	// unsorted, with an unrouted range and IPv6
+	db, err := parseASNDatabase(strings.NewReader("# ip2asn\n" +
+		"93.184.216.0\t93.184.216.255\t15133\tUS\tEDGECAST\n" +
+		"10.0.0.0\t10.255.255.255\t0\tNone\tNot routed\n" +
+		"1.1.1.0\t1.1.1.255\t13335\tUS\tCLOUDFLARENET\n" +
+		"2001:db8::\t2001:db8::ffff\t64496\tZZ\tDOCUMENTATION\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.size() != 3 {
+		t.Errorf("got %d ranges, want 3", db.size())
+	}
+	tests := []struct {
+		ip  string
+		asn string
+		org string
+	}{
+		{"93.184.216.34", "AS15133", "EDGECAST"},
+		{"1.1.1.0", "AS13335", "CLOUDFLARENET"},
+		{"1.1.1.255", "AS13335", "CLOUDFLARENET"},
+		{"2001:db8::1", "AS64496", "DOCUMENTATION"},
+		{"10.0.0.1", "", ""},
+		{"1.1.2.0", "", ""},
+		{"0.0.0.1", "", ""},
+	}
+	for _, test := range tests {
+		asn, org, ok := db.lookup(net.ParseIP(test.ip))
+		if asn != test.asn || org != test.org || ok != (test.asn != "") {
+			t.Errorf("%s: got %q, %q and %v, want %q and %q", test.ip, asn, org, ok, test.asn, test.org)
+		}
+	}
+}
+
+func TestGeoDatabaseLookup(t *testing.T) {
+	db, err := parseGeoDatabase(strings.NewReader(
+		"93.184.216.0,93.184.216.255,NA,US,California,Los Angeles,34.0522,-118.244\n" +
+			"1.1.1.0,1.1.1.255,OC,AU,Queensland,Brisbane,-27.4679,153.028\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := db.lookup(net.ParseIP("93.184.216.34")); l == nil || l.City != "Los Angeles" {
+		t.Errorf("got location %+v, want Los Angeles", l)
+	}
+	if l := db.lookup(net.ParseIP("1.1.2.0")); l != nil {
+		t.Errorf("got location %+v of an unknown address", l)
+	}
+	if _, err := parseGeoDatabase(strings.NewReader("1.1.1.255,1.1.1.0,OC,AU,,,0,0\n")); err == nil {
+		t.Error("got no error for a reversed range")
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	config Config
+	// configMutex guards config, which is replaced on reload
+	configMutex sync.RWMutex
+)
+
+type Config struct {
+	// global defaults for all hosts and modules
+	Module         `yaml:",inline"`
+	MaxConcurrency int       `yaml:"max_concurrency"`
+	LatencyBuckets []float64 `yaml:"latency_buckets"`
+	// NativeHistogramBucketFactor enables native histograms of the latency
+	// with buckets growing by at most this factor, 0 disables them
+	NativeHistogramBucketFactor float64 `yaml:"native_histogram_bucket_factor"`
+	// SeriesTTL and SeriesTTLTraces delete per hop series that were not
+	// written for a while, MaxHopSeries limits them per host
+	SeriesTTL       time.Duration `yaml:"series_ttl"`
+	SeriesTTLTraces int           `yaml:"series_ttl_traces"`
+	MaxHopSeries    int           `yaml:"max_hop_series"`
+	RouteHistory    int           `yaml:"route_history"`
+	DNSCacheTTL     time.Duration `yaml:"dns_cache_ttl"`
+	// ASNDatabase is an ip2asn file the autonomous systems of hops are
+	// looked up in, it is read again on every reload
+	ASNDatabase string `yaml:"asn_database"`
+	// GeoIPDatabase is a DB-IP city CSV file hops are located with, it is
+	// read again on every reload
+	GeoIPDatabase string            `yaml:"geoip_database"`
+	Modules       map[string]Module `yaml:"modules"`
+	Hosts         []Host            `yaml:"hosts"`
+}
+
+// Module bundles the settings of how a target is traced. Unset values are
+// taken from the global defaults, a host may override each value again.
+type Module struct {
+	Arguments      []string      `yaml:"args"`
+	Cycles         int           `yaml:"cycles"`
+	Protocol       string        `yaml:"protocol"`
+	Port           int           `yaml:"port"`
+	PacketSize     int           `yaml:"packet_size"`
+	PacketInterval float64       `yaml:"packet_interval"`
+	AddressFamily  string        `yaml:"address_family"`
+	Timeout        time.Duration `yaml:"timeout"`
+	DNS            *bool         `yaml:"dns"`
+	Interval       time.Duration `yaml:"interval"`
+	Jitter         time.Duration `yaml:"jitter"`
+	Engine         string        `yaml:"engine"`
+	Format         string        `yaml:"format"`
+	Detail         string        `yaml:"detail"`
+	MPLS           bool          `yaml:"mpls"`
+}
+
+// Host is a single trace target. Every setting apart from name and alias
+// is optional and overrides the value of the module or the global default.
+type Host struct {
+	Name       string `yaml:"name"`
+	Alias      string `yaml:"alias"`
+	ModuleName string `yaml:"module"`
+	Module     `yaml:",inline"`
+}
+
+const (
+	// defaultCycles is used when neither the global nor the host config sets cycles
+	defaultCycles = 1
+
+	defaultProtocol = "icmp"
+)
+
+var protocolFlags = map[string]string{
+	"icmp": "",
+	"tcp":  "--tcp",
+	"udp":  "--udp",
+}
+
+var engines = map[string]bool{
+	"":       true,
+	"mtr":    true,
+	"native": true,
+}
+
+// details are the levels of detail of the exported metrics
+var details = map[string]bool{
+	"":            true,
+	"destination": true,
+	"hops":        true,
+	"full":        true,
+}
+
+var addressFamilyFlags = map[string]string{
+	"":     "",
+	"ipv4": "-4",
+	"ipv6": "-6",
+}
+
+// loadConfig reads, parses and validates the config file
+func loadConfig(configFile string) (*Config, error) {
+	yamlFile, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+
+	c := &Config{}
+	err = yaml.Unmarshal(yamlFile, c)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file: %s", err)
+	}
+
+	err = c.validate()
+	if err != nil {
+		return nil, fmt.Errorf("error validating config file: %s", err)
+	}
+	return c, nil
+}
+
+// currentConfig returns a copy of the active config
+func currentConfig() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config
+}
+
+// setConfig replaces the active config
+func setConfig(c *Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config = *c
+}
+
+// lookupModule returns the module with the given name from the active config
+func lookupModule(name string) (Module, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	m, ok := config.Modules[name]
+	return m, ok
+}
+
+// validate checks the parsed config and fills in defaults
+func (c *Config) validate() error {
+	if c.Cycles == 0 {
+		c.Cycles = defaultCycles
+	}
+	if c.Protocol == "" {
+		c.Protocol = defaultProtocol
+	}
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("max_concurrency must not be negative, got %d", c.MaxConcurrency)
+	}
+	if c.SeriesTTL < 0 {
+		return fmt.Errorf("series_ttl must not be negative, got %s", c.SeriesTTL)
+	}
+	if c.SeriesTTLTraces < 0 {
+		return fmt.Errorf("series_ttl_traces must not be negative, got %d", c.SeriesTTLTraces)
+	}
+	if c.DNSCacheTTL < 0 {
+		return fmt.Errorf("dns_cache_ttl must not be negative, got %s", c.DNSCacheTTL)
+	}
+	if c.RouteHistory < 0 {
+		return fmt.Errorf("route_history must not be negative, got %d", c.RouteHistory)
+	}
+	if c.MaxHopSeries < 0 {
+		return fmt.Errorf("max_hop_series must not be negative, got %d", c.MaxHopSeries)
+	}
+	for i, b := range c.LatencyBuckets {
+		if b <= 0 || (i > 0 && b <= c.LatencyBuckets[i-1]) {
+			return fmt.Errorf("latency_buckets must be positive and in increasing order, got %v", c.LatencyBuckets)
+		}
+	}
+	if c.NativeHistogramBucketFactor != 0 && c.NativeHistogramBucketFactor <= 1 {
+		return fmt.Errorf("native_histogram_bucket_factor must be greater than 1, got %v", c.NativeHistogramBucketFactor)
+	}
+	if err := c.Module.validate(); err != nil {
+		return err
+	}
+	for name, module := range c.Modules {
+		if err := module.validate(); err != nil {
+			return fmt.Errorf("module %s: %s", name, err)
+		}
+	}
+	aliases := make(map[string]bool, len(c.Hosts))
+	for _, host := range c.Hosts {
+		if aliases[host.Alias] {
+			return fmt.Errorf("host %s: duplicate alias %q", host.Name, host.Alias)
+		}
+		aliases[host.Alias] = true
+		if _, ok := c.Modules[host.ModuleName]; host.ModuleName != "" && !ok {
+			return fmt.Errorf("host %s: unknown module %q", host.Name, host.ModuleName)
+		}
+		if err := host.Module.validate(); err != nil {
+			return fmt.Errorf("host %s: %s", host.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m Module) validate() error {
+	if m.Cycles < 0 {
+		return fmt.Errorf("cycles must not be negative, got %d", m.Cycles)
+	}
+	if _, ok := protocolFlags[m.Protocol]; m.Protocol != "" && !ok {
+		return fmt.Errorf("unknown protocol %q, must be one of icmp, tcp or udp", m.Protocol)
+	}
+	if m.Port < 0 || m.Port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535, got %d", m.Port)
+	}
+	if m.PacketSize < 0 {
+		return fmt.Errorf("packet_size must not be negative, got %d", m.PacketSize)
+	}
+	if m.PacketInterval < 0 {
+		return fmt.Errorf("packet_interval must not be negative, got %v", m.PacketInterval)
+	}
+	if _, ok := addressFamilyFlags[m.AddressFamily]; !ok {
+		return fmt.Errorf("unknown address_family %q, must be ipv4 or ipv6", m.AddressFamily)
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative, got %s", m.Timeout)
+	}
+	if m.Interval < 0 {
+		return fmt.Errorf("interval must not be negative, got %s", m.Interval)
+	}
+	if m.Jitter < 0 {
+		return fmt.Errorf("jitter must not be negative, got %s", m.Jitter)
+	}
+	if !engines[m.Engine] {
+		return fmt.Errorf("unknown engine %q, must be mtr or native", m.Engine)
+	}
+	if _, ok := parsers[m.Format]; m.Format != "" && !ok {
+		return fmt.Errorf("unknown format %q, must be one of raw, json, xml or csv", m.Format)
+	}
+	if !details[m.Detail] {
+		return fmt.Errorf("unknown detail %q, must be one of destination, hops or full", m.Detail)
+	}
+	return nil
+}
+
+// merge returns m with all values set in o replacing those of m,
+// arguments are appended
+func (m Module) merge(o Module) Module {
+	m.Arguments = append(append([]string{}, m.Arguments...), o.Arguments...)
+	if o.Cycles > 0 {
+		m.Cycles = o.Cycles
+	}
+	if o.Protocol != "" {
+		m.Protocol = o.Protocol
+	}
+	if o.Port > 0 {
+		m.Port = o.Port
+	}
+	if o.PacketSize > 0 {
+		m.PacketSize = o.PacketSize
+	}
+	if o.PacketInterval > 0 {
+		m.PacketInterval = o.PacketInterval
+	}
+	if o.AddressFamily != "" {
+		m.AddressFamily = o.AddressFamily
+	}
+	if o.Timeout > 0 {
+		m.Timeout = o.Timeout
+	}
+	if o.DNS != nil {
+		m.DNS = o.DNS
+	}
+	if o.Interval > 0 {
+		m.Interval = o.Interval
+	}
+	if o.Jitter > 0 {
+		m.Jitter = o.Jitter
+	}
+	if o.Engine != "" {
+		m.Engine = o.Engine
+	}
+	if o.Format != "" {
+		m.Format = o.Format
+	}
+	if o.Detail != "" {
+		m.Detail = o.Detail
+	}
+	if o.MPLS {
+		m.MPLS = true
+	}
+	return m
+}
+
+// settings returns the effective settings for the host: the global defaults,
+// overridden by the host's module, overridden by the host itself
+func (h Host) settings() Module {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config.Module.merge(config.Modules[h.ModuleName]).merge(h.Module)
+}
+
+// cycles returns the effective number of report cycles for the host
+func (h Host) cycles() int {
+	return h.settings().Cycles
+}
+
+// protocol returns the effective probe protocol for the host
+func (h Host) protocol() string {
+	return h.settings().Protocol
+}
+
+// timeout returns how long a single trace may take, 0 means no limit
+func (h Host) timeout() time.Duration {
+	return h.settings().Timeout
+}
+
+// interval returns the time between the starts of two traces, 0 means
+// the next trace starts as soon as the previous one finished
+func (h Host) interval() time.Duration {
+	return h.settings().Interval
+}
+
+// jitter returns the maximum random delay before the first trace
+func (h Host) jitter() time.Duration {
+	return h.settings().Jitter
+}
+
+// engine returns the engine tracing the host, mtr or native
+func (h Host) engine() string {
+	if e := h.settings().Engine; e != "" {
+		return e
+	}
+	return "mtr"
+}
+
+// format returns the mtr output format parsed for the host
+func (h Host) format() string {
+	if f := h.settings().Format; f != "" {
+		return f
+	}
+	return "raw"
+}
+
+// detail returns which metrics are exported for the host: destination for
+// end-to-end metrics only, hops adds per hop gauges, full adds all per hop
+// and per address metrics
+func (h Host) detail() string {
+	if d := h.settings().Detail; d != "" {
+		return d
+	}
+	return "full"
+}
+
+// portLabel returns the port as used in metric labels, empty if the protocol has no ports
+func (h Host) portLabel() string {
+	s := h.settings()
+	if s.Protocol == "icmp" || s.Port == 0 {
+		return ""
+	}
+	return strconv.Itoa(s.Port)
+}
+
+// arguments converts the effective settings into the extra mtr arguments
+func (h Host) arguments() []string {
+	s := h.settings()
+	args := s.Arguments
+
+	if flag := protocolFlags[s.Protocol]; flag != "" {
+		args = append(args, flag)
+	}
+	if s.Port > 0 && s.Protocol != "icmp" {
+		args = append(args, "--port", strconv.Itoa(s.Port))
+	}
+	if s.PacketSize > 0 {
+		args = append(args, "--psize", strconv.Itoa(s.PacketSize))
+	}
+	if s.PacketInterval > 0 {
+		args = append(args, "--interval", strconv.FormatFloat(s.PacketInterval, 'f', -1, 64))
+	}
+	if flag := addressFamilyFlags[s.AddressFamily]; flag != "" {
+		args = append(args, flag)
+	}
+	if s.DNS != nil && !*s.DNS {
+		args = append(args, "--no-dns")
+	}
+	if s.MPLS {
+		args = append(args, "--mpls")
+	}
+
+	return args
+}
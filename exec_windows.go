@@ -0,0 +1,7 @@
+package main
+
+import "os/exec"
+
+// setProcessGroup keeps the default of killing only the process itself,
+// windows has no process groups to kill
+func setProcessGroup(cmd *exec.Cmd) {}
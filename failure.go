@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// reasons for failed traces, used in the reason label of mtr_failed
+const (
+	reasonTimeout    = "timeout"
+	reasonCancelled  = "cancelled"
+	reasonNotFound   = "not_found"
+	reasonPermission = "permission"
+	reasonDNS        = "dns"
+	reasonExit       = "exit"
+	reasonParse      = "parse"
+	reasonNoReply    = "no_reply"
+	reasonError      = "error"
+)
+
+// errNoReply is returned for traces without a single hop
+var errNoReply = &TraceError{Reason: reasonNoReply, Err: errors.New("no hop answered")}
+
+// stderrTailSize is how much of mtr's error output is kept
+const stderrTailSize = 512
+
+// TraceError is returned by probers for failed traces, along with the reason
+// the trace failed for
+type TraceError struct {
+	Reason string
+	Err    error
+	// Stderr is the end of mtr's error output, if any
+	Stderr string
+}
+
+func (e *TraceError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("%s: %s", e.Err, e.Stderr)
+	}
+	return e.Err.Error()
+}
+
+func (e *TraceError) Unwrap() error {
+	return e.Err
+}
+
+// stderrReasons maps messages mtr prints on stderr to failure reasons
+var stderrReasons = []struct {
+	substring string
+	reason    string
+}{
+	{"Failed to resolve host", reasonDNS},
+	{"Name or service not known", reasonDNS},
+	{"Temporary failure in name resolution", reasonDNS},
+	{"Permission denied", reasonPermission},
+	{"Operation not permitted", reasonPermission},
+	{"mtr-packet: not found", reasonNotFound},
+}
+
+// newExitError classifies a failed mtr run by its exit error and error output
+func newExitError(err error, stderr string) *TraceError {
+	stderr = strings.TrimSpace(stderr)
+	if len(stderr) > stderrTailSize {
+		stderr = "..." + stderr[len(stderr)-stderrTailSize:]
+	}
+
+	reason := reasonExit
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		// mtr could not be started at all
+		reason = failureReason(err)
+	} else {
+		for _, r := range stderrReasons {
+			if strings.Contains(stderr, r.substring) {
+				reason = r.reason
+				break
+			}
+		}
+	}
+	return &TraceError{Reason: reason, Err: fmt.Errorf("mtr failed: %w", err), Stderr: stderr}
+}
+
+// failureReason classifies the error of a failed trace for the reason label
+func failureReason(err error) string {
+	var traceErr *TraceError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &traceErr):
+		return traceErr.Reason
+	case errors.Is(err, context.DeadlineExceeded):
+		return reasonTimeout
+	case errors.Is(err, context.Canceled):
+		return reasonCancelled
+	case errors.Is(err, exec.ErrNotFound):
+		return reasonNotFound
+	case errors.Is(err, os.ErrPermission):
+		return reasonPermission
+	case errors.As(err, &dnsErr):
+		return reasonDNS
+	}
+	return reasonError
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+)
+
+const (
+	// earthRadiusKm is the mean radius of the earth
+	earthRadiusKm = 6371.0
+
+	// fiberKmPerMicrosecond is the speed of light in optical fiber, about
+	// two thirds of that in vacuum
+	fiberKmPerMicrosecond = 0.2
+
+	// minFloorDistanceKm is the distance below which no RTT floor is
+	// computed, geolocation is only accurate to about the city
+	minFloorDistanceKm = 100.0
+)
+
+// geoLocation is where an address is located
+type geoLocation struct {
+	Country   string
+	City      string
+	Latitude  float64
+	Longitude float64
+}
+
+// geoDatabase maps addresses to locations. It is read from a CSV file in the
+// format of the DB-IP city lite database: range start, range end, continent,
+// country code, region, city, latitude and longitude, optionally gzip
+// compressed.
+type geoDatabase struct {
+	ranges rangeTable
+}
+
+var (
+	geoDB *geoDatabase
+	// geoMutex guards geoDB, which is replaced on reload
+	geoMutex sync.RWMutex
+)
+
+// currentGeoDatabase returns the active GeoIP database, nil if none is configured
+func currentGeoDatabase() *geoDatabase {
+	geoMutex.RLock()
+	defer geoMutex.RUnlock()
+	return geoDB
+}
+
+// setGeoDatabase replaces the active GeoIP database
+func setGeoDatabase(db *geoDatabase) {
+	geoMutex.Lock()
+	defer geoMutex.Unlock()
+	geoDB = db
+}
+
+// loadGeoDatabase reads the GeoIP file, it returns nil if file is empty
+func loadGeoDatabase(file string) (*geoDatabase, error) {
+	if file == "" {
+		return nil, nil
+	}
+	var db *geoDatabase
+	err := readDatabase(file, func(r io.Reader) (err error) {
+		db, err = parseGeoDatabase(r)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading GeoIP database %s: %s", file, err)
+	}
+	return db, nil
+}
+
+// parseGeoDatabase parses the CSV records of the GeoIP database
+func parseGeoDatabase(r io.Reader) (*geoDatabase, error) {
+	db := &geoDatabase{}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 8 {
+			return nil, fmt.Errorf("line %d: expected 8 fields, got %d", line, len(record))
+		}
+		latitude, err := strconv.ParseFloat(record[6], 64)
+		if err != nil || latitude < -90 || latitude > 90 {
+			return nil, fmt.Errorf("line %d: invalid latitude %q", line, record[6])
+		}
+		longitude, err := strconv.ParseFloat(record[7], 64)
+		if err != nil || longitude < -180 || longitude > 180 {
+			return nil, fmt.Errorf("line %d: invalid longitude %q", line, record[7])
+		}
+		location := &geoLocation{
+			Country:   record[3],
+			City:      record[5],
+			Latitude:  latitude,
+			Longitude: longitude,
+		}
+		if err := db.ranges.add(record[0], record[1], location); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	db.ranges.sort()
+	return db, nil
+}
+
+// size returns the number of address ranges of the database, 0 for nil
+func (db *geoDatabase) size() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.ranges)
+}
+
+// lookup returns the location of the address, nil if it is unknown
+func (db *geoDatabase) lookup(ip net.IP) *geoLocation {
+	location, _ := db.ranges.lookup(ip).(*geoLocation)
+	return location
+}
+
+// labelValues returns the country, city, latitude and longitude labels
+func (l *geoLocation) labelValues() []string {
+	return []string{
+		l.Country,
+		l.City,
+		strconv.FormatFloat(l.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(l.Longitude, 'f', -1, 64),
+	}
+}
+
+// distance returns the great circle distance between two locations in km
+func (l *geoLocation) distance(o *geoLocation) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(o.Latitude - l.Latitude)
+	dLon := rad(o.Longitude - l.Longitude)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(l.Latitude))*math.Cos(rad(o.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// enrichGeo sets the locations of all responders from the GeoIP database and
+// computes the RTT floor of each located hop, the round trip time light in
+// fiber needs for the distance from the previous located hop. The inflation
+// is how many times the floor the best RTTs of the two hops are apart, 0 if
+// the hop answered faster than the previous one. Hops closer than
+// minFloorDistanceKm have no floor, the locations are too coarse.
+func enrichGeo(result *TraceResult) {
+	db := currentGeoDatabase()
+	if db == nil || result == nil {
+		return
+	}
+	var previous *Hop
+	for _, hop := range result.Hops {
+		for _, r := range hop.Responders {
+			r.Geo = db.lookup(r.IP)
+			if r.IP.Equal(hop.IP) {
+				hop.Geo = r.Geo
+			}
+		}
+		if hop.Geo == nil || hop.Received == 0 {
+			continue
+		}
+		if previous != nil {
+			if d := previous.Geo.distance(hop.Geo); d >= minFloorDistanceKm {
+				hop.RTTFloor = 2 * d / fiberKmPerMicrosecond
+				// a router slow to answer itself can be ahead of the next hop
+				if delta := hop.Best - previous.Best; delta > 0 {
+					hop.Inflation = float64(delta) / hop.RTTFloor
+				}
+			}
+		}
+		previous = hop
+	}
+}
@@ -1,20 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"reflect"
 	"strconv"
 	"sync"
+	"syscall"
+	"time"
 
-	"gopkg.in/yaml.v2"
-
-	mtr "github.com/Shinzu/go-mtr"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 )
@@ -25,42 +27,107 @@ type Exporter struct {
 	received           *prometheus.CounterVec
 	dropped            *prometheus.CounterVec
 	lost               *prometheus.CounterVec
-	latency            *prometheus.SummaryVec
+	latency            *prometheus.HistogramVec
+	latencyBuckets     []float64
+	latencyFactor      float64
 	routeChanges       *prometheus.CounterVec
 	destinationChanges *prometheus.CounterVec
+	routeInfo          *prometheus.GaugeVec
 	failed             *prometheus.CounterVec
+	traceDuration      *prometheus.GaugeVec
+	cycles             *prometheus.GaugeVec
+	responders         *prometheus.GaugeVec
+	best               *prometheus.GaugeVec
+	worst              *prometheus.GaugeVec
+	stddev             *prometheus.GaugeVec
+	jitterMean         *prometheus.GaugeVec
+	jitterWorst        *prometheus.GaugeVec
+	jitterInterarrival *prometheus.GaugeVec
+	hopLoss            *prometheus.GaugeVec
+	hopLatency         *prometheus.GaugeVec
+	hopEffectiveLoss   *prometheus.GaugeVec
+	lossOrigin         *prometheus.GaugeVec
+	hopInfo            *prometheus.GaugeVec
+	hopASN             *prometheus.GaugeVec
+	asPathInfo         *prometheus.GaugeVec
+	asPathChanges      *prometheus.CounterVec
+	asnRanges          prometheus.Gauge
+	hopGeo             *prometheus.GaugeVec
+	hopMPLS            *prometheus.GaugeVec
+	hopRTTFloor        *prometheus.GaugeVec
+	hopInflation       *prometheus.GaugeVec
+	geoRanges          prometheus.Gauge
+	traceHops          *prometheus.GaugeVec
+	traceResponding    *prometheus.GaugeVec
+	destLoss           *prometheus.GaugeVec
+	destLatency        *prometheus.GaugeVec
+	destReached        *prometheus.GaugeVec
+	destSent           *prometheus.CounterVec
+	destReceived       *prometheus.CounterVec
+	destUnreachable    *prometheus.CounterVec
+	traceTimestamp     *prometheus.GaugeVec
+	seriesCount        *prometheus.GaugeVec
+	seriesRejected     *prometheus.CounterVec
+	reloadSuccess      prometheus.Gauge
+	reloadSeconds      prometheus.Gauge
 	lastDest           map[string]net.IP
-	lastRoute          map[string][]net.IP
-}
-
-type Config struct {
-	Arguments []string `yaml:"args"`
-	Hosts     []Host   `yaml:"hosts"`
-}
-
-type Host struct {
-	Name  string `yaml:"name"`
-	Alias string `yaml:"alias"`
+	lastRoute          map[string][]string
+	lastASPath         map[string]string
+	results            chan *TargetFeedback
+	semaphore          chan struct{}
+	workers            map[string]*targetWorker
+	nextWorkerID       int
+	series             *seriesTracker
+	probers            map[string]Prober
+	status             map[string]*targetStatus
+	lastGauges         map[string]traceGauges
+	routes             map[string][]*routePath
+	stateDir           string
+	stateWrites        chan stateWrite
 }
 
 type TargetFeedback struct {
-	Target string
-	Alias  string
-	Hosts  []*mtr.Host
+	Target   string
+	Alias    string
+	Protocol string
+	Port     string
+	Detail   string
+	Result   *TraceResult
+	Err      error
+	Duration time.Duration
+	worker   int
 }
 
-var config Config
+// targetWorker traces a single host until its context is cancelled
+type targetWorker struct {
+	id       int
+	host     Host
+	settings Module
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
 
 const (
 	Namespace = "mtr"
 )
 
+// newSemaphore returns a semaphore for max concurrent traces, nil for no limit
+func newSemaphore(max int) chan struct{} {
+	if max > 0 {
+		return make(chan struct{}, max)
+	}
+	return nil
+}
+
 func NewExporter() *Exporter {
 	var (
 		alias        = "alias"
 		server       = "server"
+		protocol     = "protocol"
+		port         = "port"
 		hop_id       = "hop_id"
 		hop_ip       = "hop_ip"
+		reason       = "reason"
 		previousDest = "previous"
 		currentDest  = "current"
 	)
@@ -72,7 +139,7 @@ func NewExporter() *Exporter {
 				Name:      "sent",
 				Help:      "packets sent",
 			},
-			[]string{alias, server, hop_id, hop_ip},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
 		),
 		received: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -80,7 +147,7 @@ func NewExporter() *Exporter {
 				Name:      "received",
 				Help:      "packets received",
 			},
-			[]string{alias, server, hop_id, hop_ip},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
 		),
 		dropped: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -88,7 +155,7 @@ func NewExporter() *Exporter {
 				Name:      "dropped",
 				Help:      "packets dropped",
 			},
-			[]string{alias, server, hop_id, hop_ip},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
 		),
 		lost: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -96,31 +163,18 @@ func NewExporter() *Exporter {
 				Name:      "lost",
 				Help:      "packets lost",
 			},
-			[]string{alias, server, hop_id, hop_ip},
-		),
-		latency: prometheus.NewSummaryVec(
-			prometheus.SummaryOpts{
-				Namespace: Namespace,
-				Name:      "latency",
-				Help:      "packet latency in microseconds",
-				Objectives: map[float64]float64{
-					0.5:  0.05,
-					0.9:  0.01,
-					0.99: 0.001,
-				},
-				MaxAge:     prometheus.DefMaxAge,
-				AgeBuckets: prometheus.DefAgeBuckets,
-				BufCap:     prometheus.DefBufCap,
-			},
-			[]string{alias, server, hop_id, hop_ip},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
 		),
+		latency:        newLatencyHistogram(config.LatencyBuckets, config.NativeHistogramBucketFactor),
+		latencyBuckets: config.LatencyBuckets,
+		latencyFactor:  config.NativeHistogramBucketFactor,
 		routeChanges: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Namespace: Namespace,
 				Name:      "route_changes",
 				Help:      "route changes",
 			},
-			[]string{alias, server, hop_id},
+			[]string{alias, server, protocol, port, hop_id},
 		),
 		destinationChanges: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -128,7 +182,15 @@ func NewExporter() *Exporter {
 				Name:      "destination_changes",
 				Help:      "Number of times the destination IP has changed",
 			},
-			[]string{alias, server, previousDest, currentDest},
+			[]string{alias, server, protocol, port, previousDest, currentDest},
+		),
+		routeInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "route_info",
+				Help:      "fingerprint of the path of the last MTR run, see /api/v1/routes/<alias> for its hops",
+			},
+			[]string{alias, server, protocol, port, "fingerprint"},
 		),
 		failed: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -136,10 +198,351 @@ func NewExporter() *Exporter {
 				Name:      "failed",
 				Help:      "MTR runs failed",
 			},
-			[]string{alias, server},
+			[]string{alias, server, protocol, port, reason},
+		),
+		traceDuration: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "trace_duration_seconds",
+				Help:      "how long the last MTR run took in seconds",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		cycles: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "cycles",
+				Help:      "number of report cycles per MTR run",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		responders: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_responders",
+				Help:      "number of addresses that answered for the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id},
+		),
+		best: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_best_seconds",
+				Help:      "lowest round trip time of the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		worst: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_worst_seconds",
+				Help:      "highest round trip time of the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		stddev: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_stddev_seconds",
+				Help:      "standard deviation of the round trip times of the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		jitterMean: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_jitter_mean_seconds",
+				Help:      "mean difference between consecutive round trip times of the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		jitterWorst: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_jitter_worst_seconds",
+				Help:      "highest difference between consecutive round trip times of the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		jitterInterarrival: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_jitter_interarrival_seconds",
+				Help:      "interarrival jitter of the hop as defined by RFC 3550 in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		hopLoss: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_loss_ratio",
+				Help:      "ratio of packets lost at the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		hopEffectiveLoss: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_effective_loss_ratio",
+				Help:      "ratio of packets lost at the hop and all hops after it in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		lossOrigin: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "loss_origin_hop",
+				Help:      "first hop of the end-to-end loss in the last MTR run, -1 without loss",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		hopLatency: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_latency_seconds",
+				Help:      "mean round trip time of the hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		hopInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_info",
+				Help:      "host name of a hop address in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip, "hop_name"},
+		),
+		hopASN: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_asn_info",
+				Help:      "autonomous system of a hop address in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip, "asn", "as_org"},
+		),
+		asPathInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "as_path_info",
+				Help:      "autonomous systems passed by the last MTR run that knew any, in order",
+			},
+			[]string{alias, server, protocol, port, "as_path"},
+		),
+		asPathChanges: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "as_path_changes",
+				Help:      "AS path changes",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		asnRanges: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "asn_database_ranges",
+				Help:      "number of address ranges in the loaded ASN database",
+			},
+		),
+		hopGeo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_geo_info",
+				Help:      "location of a hop address in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip, "country", "city", "latitude", "longitude"},
+		),
+		hopMPLS: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_mpls_info",
+				Help:      "MPLS label stack reported by a hop address in the last MTR run, top label first",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip, "mpls_labels"},
+		),
+		hopRTTFloor: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_rtt_floor_seconds",
+				Help:      "round trip time light in fiber needs from the previous located hop in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		hopInflation: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "hop_latency_inflation_ratio",
+				Help:      "best round trip time from the previous located hop divided by the RTT floor in the last MTR run",
+			},
+			[]string{alias, server, protocol, port, hop_id, hop_ip},
+		),
+		geoRanges: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "geoip_database_ranges",
+				Help:      "number of address ranges in the loaded GeoIP database",
+			},
+		),
+		traceHops: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "trace_hops",
+				Help:      "number of hops in the last MTR run",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		traceResponding: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "trace_responding_hops",
+				Help:      "number of hops that answered in the last MTR run",
+			},
+			[]string{alias, server, protocol, port},
 		),
-		lastDest:  make(map[string]net.IP, len(config.Hosts)),
-		lastRoute: make(map[string][]net.IP, len(config.Hosts)),
+		destLoss: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "destination_loss_ratio",
+				Help:      "ratio of packets to the target lost in the last MTR run",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		destLatency: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "destination_latency_seconds",
+				Help:      "mean round trip time to the target in the last MTR run",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		destReached: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "destination_reached",
+				Help:      "whether the target address answered in the last MTR run",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		destSent: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "destination_sent",
+				Help:      "packets sent to the target",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		destReceived: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "destination_received",
+				Help:      "packets received from the target",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		destUnreachable: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "destination_unreachable",
+				Help:      "MTR runs that did not reach the target",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		traceTimestamp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "trace_timestamp_seconds",
+				Help:      "time the last successful MTR run finished",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		seriesCount: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "series",
+				Help:      "number of series exported for the host",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		seriesRejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "hop_series_rejected",
+				Help:      "hop addresses not exported because max_hop_series was reached",
+			},
+			[]string{alias, server, protocol, port},
+		),
+		reloadSuccess: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "config_last_reload_successful",
+				Help:      "whether the last configuration reload attempt was successful",
+			},
+		),
+		reloadSeconds: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "config_last_reload_success_timestamp_seconds",
+				Help:      "timestamp of the last successful configuration reload",
+			},
+		),
+		lastDest:    make(map[string]net.IP, len(config.Hosts)),
+		lastRoute:   make(map[string][]string, len(config.Hosts)),
+		lastASPath:  make(map[string]string, len(config.Hosts)),
+		results:     make(chan *TargetFeedback),
+		semaphore:   newSemaphore(config.MaxConcurrency),
+		workers:     make(map[string]*targetWorker, len(config.Hosts)),
+		series:      newSeriesTracker(),
+		probers:     defaultProbers,
+		status:      make(map[string]*targetStatus, len(config.Hosts)),
+		lastGauges:  make(map[string]traceGauges, len(config.Hosts)),
+		routes:      make(map[string][]*routePath, len(config.Hosts)),
+		stateWrites: make(chan stateWrite, 64),
+	}
+}
+
+// defaultLatencyBuckets range from 0.5ms to about 4s
+var defaultLatencyBuckets = prometheus.ExponentialBuckets(0.0005, 2, 14)
+
+const (
+	// nativeHistogramMaxBuckets limits the buckets of a native latency
+	// histogram, the resolution is halved when they are exceeded
+	nativeHistogramMaxBuckets = 160
+	// nativeHistogramMinReset is how long a native histogram that reduced
+	// its resolution keeps it before it is reset
+	nativeHistogramMinReset = time.Hour
+)
+
+// newLatencyHistogram returns the round trip time histogram with the
+// buckets in seconds, the default buckets if none are given. With a native
+// bucket factor it is a native histogram as well, the classic buckets are
+// still exposed to scrapers that don't support native histograms.
+func newLatencyHistogram(buckets []float64, nativeFactor float64) *prometheus.HistogramVec {
+	if len(buckets) == 0 {
+		buckets = defaultLatencyBuckets
+	}
+	opts := prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "latency_seconds",
+		Help:      "packet round trip time in seconds",
+		Buckets:   buckets,
+	}
+	if nativeFactor > 0 {
+		opts.NativeHistogramBucketFactor = nativeFactor
+		opts.NativeHistogramMaxBucketNumber = nativeHistogramMaxBuckets
+		opts.NativeHistogramMinResetDuration = nativeHistogramMinReset
+	}
+	return prometheus.NewHistogramVec(opts, []string{"alias", "server", "protocol", "port", "hop_id", "hop_ip"})
+}
+
+// observeLatency records the round trip time of every reply of the responder
+func observeLatency(h prometheus.Observer, r *Responder) {
+	for _, t := range r.PacketMicrosecs {
+		h.Observe(float64(t) / 1e6)
+	}
+	if len(r.PacketMicrosecs) == 0 {
+		// the report formats only provide the mean, count it once per reply
+		for i := 0; i < r.Received; i++ {
+			h.Observe(r.Mean / 1e6)
+		}
 	}
 }
 
@@ -151,7 +554,44 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.latency.Describe(ch)
 	e.routeChanges.Describe(ch)
 	e.destinationChanges.Describe(ch)
+	e.routeInfo.Describe(ch)
 	e.failed.Describe(ch)
+	e.traceDuration.Describe(ch)
+	e.cycles.Describe(ch)
+	e.responders.Describe(ch)
+	e.best.Describe(ch)
+	e.worst.Describe(ch)
+	e.stddev.Describe(ch)
+	e.jitterMean.Describe(ch)
+	e.jitterWorst.Describe(ch)
+	e.jitterInterarrival.Describe(ch)
+	e.hopLoss.Describe(ch)
+	e.hopLatency.Describe(ch)
+	e.hopEffectiveLoss.Describe(ch)
+	e.lossOrigin.Describe(ch)
+	e.hopInfo.Describe(ch)
+	e.hopASN.Describe(ch)
+	e.asPathInfo.Describe(ch)
+	e.asPathChanges.Describe(ch)
+	e.asnRanges.Describe(ch)
+	e.hopGeo.Describe(ch)
+	e.hopMPLS.Describe(ch)
+	e.hopRTTFloor.Describe(ch)
+	e.hopInflation.Describe(ch)
+	e.geoRanges.Describe(ch)
+	e.traceHops.Describe(ch)
+	e.traceResponding.Describe(ch)
+	e.destLoss.Describe(ch)
+	e.destLatency.Describe(ch)
+	e.destReached.Describe(ch)
+	e.destSent.Describe(ch)
+	e.destReceived.Describe(ch)
+	e.destUnreachable.Describe(ch)
+	e.traceTimestamp.Describe(ch)
+	e.seriesCount.Describe(ch)
+	e.seriesRejected.Describe(ch)
+	e.reloadSuccess.Describe(ch)
+	e.reloadSeconds.Describe(ch)
 }
 
 func min(a int, b int) int {
@@ -161,62 +601,356 @@ func min(a int, b int) int {
 	return a
 }
 
-func (e *Exporter) collect() error {
+// collect starts a worker per host and processes their results
+func (e *Exporter) collect() {
+	e.mutex.Lock()
+	e.updateWorkers(currentConfig().Hosts)
+	e.mutex.Unlock()
+
+	for tf := range e.results {
+		e.process(tf)
+	}
+}
+
+// updateWorkers starts workers for new or changed hosts and stops those of
+// removed or changed hosts. Workers of unchanged hosts keep running and keep
+// their state. The caller must hold e.mutex.
+func (e *Exporter) updateWorkers(hosts []Host) {
+	workers := make(map[string]*targetWorker, len(hosts))
+	var started []*targetWorker
+	for _, host := range hosts {
+		settings := host.settings()
+		if w, ok := e.workers[host.Alias]; ok && reflect.DeepEqual(w.host, host) && reflect.DeepEqual(w.settings, settings) {
+			workers[host.Alias] = w
+			delete(e.workers, host.Alias)
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		w := &targetWorker{
+			id:       e.nextWorkerID,
+			host:     host,
+			settings: settings,
+			ctx:      ctx,
+			cancel:   cancel,
+		}
+		e.nextWorkerID++
+		workers[host.Alias] = w
+		started = append(started, w)
+	}
+
+	// everything left over was removed or changed
+	for alias, w := range e.workers {
+		log.Infoln("stopping worker", w.id, "for", w.host.Name, "aliased as", alias)
+		w.cancel()
+		e.series.delete(alias)
+		delete(e.lastRoute, alias)
+		delete(e.lastASPath, alias)
+		delete(e.lastDest, alias)
+		delete(e.status, alias)
+		delete(e.lastGauges, alias)
+		delete(e.routes, alias)
+		e.removeState(alias)
+	}
+	e.workers = workers
+
+	for _, w := range started {
+		labels := []string{w.host.Alias, w.host.Name, w.host.protocol(), w.host.portLabel()}
+		e.cycles.WithLabelValues(labels...).Set(float64(w.host.cycles()))
+		e.series.add(w.host.Alias, labels, e.cycles)
+		go e.schedule(w)
+	}
+}
+
+// schedule traces the host every interval until the worker is stopped. The first
+// trace is delayed by a random jitter so that hosts don't run in lockstep.
+func (e *Exporter) schedule(w *targetWorker) {
+	host := w.host
+	if jitter := host.jitter(); jitter > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+		case <-w.ctx.Done():
+			return
+		}
+	}
+
 	for {
-		results := make(chan *TargetFeedback)
-		wg := &sync.WaitGroup{}
-		wg.Add(len(config.Hosts))
-
-		for w, host := range config.Hosts {
-			go func(w int, host Host) {
-				log.Infoln("worker", w, "processing job", host.Name, "aliased as", host.Alias)
-				err := worker(w, host, results, wg)
-				if err != nil {
-					log.Errorf("worker %d failed job %v aliased as %v: %v\n", w, host.Name, host.Alias, err)
-					e.failed.WithLabelValues(host.Alias, host.Name).Inc()
-				} else {
-					log.Infoln("worker", w, "finished job", host.Name, "aliased as", host.Alias)
-				}
-			}(w, host)
+		start := time.Now()
+		log.Infoln("worker", w.id, "processing job", host.Name, "aliased as", host.Alias)
+		tf := e.worker(w)
+		if w.ctx.Err() != nil {
+			return
+		}
+		if tf.Err != nil {
+			log.Errorf("worker %d failed job %v aliased as %v after %v: %v\n", w.id, host.Name, host.Alias, tf.Duration, tf.Err)
+		} else {
+			log.Infoln("worker", w.id, "finished job", host.Name, "aliased as", host.Alias, "in", tf.Duration)
 		}
+		select {
+		case e.results <- tf:
+		case <-w.ctx.Done():
+			return
+		}
+
+		wait := host.interval() - time.Since(start)
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-time.After(wait):
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// current reports whether w is the active worker of its alias. The caller
+// must hold e.mutex.
+func (e *Exporter) current(alias string, id int) bool {
+	w, ok := e.workers[alias]
+	return ok && w.id == id
+}
+
+// process updates the metrics with the result of a single trace
+func (e *Exporter) process(tf *TargetFeedback) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !e.current(tf.Alias, tf.worker) {
+		// the host was removed or changed while it was traced
+		return
+	}
+
+	if tf.Err == nil && (tf.Result == nil || len(tf.Result.Hops) == 0) {
+		tf.Err = errNoReply
+	}
+	e.series.trace(tf.Alias)
+	e.setStatus(tf)
+	labels := []string{tf.Alias, tf.Target, tf.Protocol, tf.Port}
+	defer e.expireSeries(labels)
+	e.traceDuration.WithLabelValues(labels...).Set(tf.Duration.Seconds())
+	e.series.add(tf.Alias, labels, e.traceDuration)
+	if tf.Err != nil {
+		labels = append(labels, failureReason(tf.Err))
+		e.failed.WithLabelValues(labels...).Inc()
+		e.series.add(tf.Alias, labels, e.failed)
+		return
+	}
+
+	defer e.saveState(labels)
 
-		go func() {
-			wg.Wait()
-			close(results)
-		}()
-
-		for tf := range results {
-			route := make([]net.IP, len(tf.Hosts))
-			destination := tf.Hosts[len(tf.Hosts)-1].IP
-			for i, host := range tf.Hosts {
-				route[i] = host.IP
-				e.sent.WithLabelValues(tf.Alias, tf.Target, strconv.Itoa(host.Hop), host.IP.String()).Add(float64(host.Sent))
-				e.received.WithLabelValues(tf.Alias, tf.Target, strconv.Itoa(host.Hop), host.IP.String()).Add(float64(host.Received))
-				e.dropped.WithLabelValues(tf.Alias, tf.Target, strconv.Itoa(host.Hop), host.IP.String()).Add(float64(host.Dropped))
-				e.lost.WithLabelValues(tf.Alias, tf.Target, strconv.Itoa(host.Hop), host.IP.String()).Add(host.LostPercent * float64(host.Sent))
-				e.latency.WithLabelValues(tf.Alias, tf.Target, strconv.Itoa(host.Hop), host.IP.String()).Observe(host.Mean)
+	hops := tf.Result.Hops
+	// the route is the set of responders per hop, so that load balanced
+	// paths answering from a different address each time are no route change
+	route := make([]string, len(hops))
+	last := hops[len(hops)-1]
+	destination := tf.Result.Destination
+	loss := tf.Result.effectiveLoss()
+	gauges := make(traceGauges)
+	responding, rejected := 0, 0
+	for i, host := range hops {
+		route[i] = host.responderSet()
+		if host.Received > 0 {
+			responding++
+		}
+		if tf.Detail != "destination" {
+			rejected += e.processHop(tf, host, loss[i], gauges)
+		}
+	}
+	for key, lvs := range gauges {
+		e.series.add(tf.Alias, lvs, key.vec)
+	}
+	gauges.deleteStale(e.lastGauges[tf.Alias], e.series, tf.Alias)
+	e.lastGauges[tf.Alias] = gauges
+	if rejected > 0 {
+		e.seriesRejected.WithLabelValues(labels...).Add(float64(rejected))
+		e.series.add(tf.Alias, labels, e.seriesRejected)
+	}
+
+	e.traceHops.WithLabelValues(labels...).Set(float64(len(hops)))
+	e.traceResponding.WithLabelValues(labels...).Set(float64(responding))
+	e.traceTimestamp.WithLabelValues(labels...).Set(float64(tf.Result.Start.Add(tf.Result.Duration).UnixNano()) / 1e9)
+	e.lossOrigin.WithLabelValues(labels...).Set(float64(tf.Result.lossOrigin(loss)))
+	e.series.add(tf.Alias, labels, e.traceHops, e.traceResponding, e.traceTimestamp, e.lossOrigin)
+
+	// end-to-end metrics, exported at every detail. If the trace stopped
+	// short of the destination, the last hop is some router on the way and
+	// all packets count as lost.
+	e.destSent.WithLabelValues(labels...).Add(float64(last.Sent))
+	e.series.add(tf.Alias, labels, e.destLoss, e.destLatency, e.destReached, e.destSent, e.destReceived)
+	if tf.Result.Reached {
+		e.destLoss.WithLabelValues(labels...).Set(last.LostPercent)
+		e.destLatency.WithLabelValues(labels...).Set(last.Mean / 1e6)
+		e.destReached.WithLabelValues(labels...).Set(1)
+		e.destReceived.WithLabelValues(labels...).Add(float64(last.Received))
+	} else {
+		e.destLoss.WithLabelValues(labels...).Set(1)
+		e.destLatency.DeleteLabelValues(labels...)
+		e.destReached.WithLabelValues(labels...).Set(0)
+		e.destReceived.WithLabelValues(labels...).Add(0)
+		e.destUnreachable.WithLabelValues(labels...).Inc()
+		e.series.add(tf.Alias, labels, e.destUnreachable)
+	}
+
+	if e.lastRoute[tf.Alias] != nil && tf.Detail != "destination" {
+		m := min(len(route), len(e.lastRoute[tf.Alias]))
+		if len(route) != len(e.lastRoute[tf.Alias]) {
+			e.routeChanges.WithLabelValues(tf.Alias, tf.Target, tf.Protocol, tf.Port, strconv.Itoa(m)).Inc()
+			e.series.add(tf.Alias, []string{tf.Alias, tf.Target, tf.Protocol, tf.Port, strconv.Itoa(m)}, e.routeChanges)
+		} else {
+			// m - 1 because if the routes are the same apart from the destination, it's
+			// just the destination that's changed, and that's recorded separately below.
+			// Without the destination the last hop is a router that is compared as well.
+			n := m
+			if tf.Result.Reached {
+				n = m - 1
 			}
-			if e.lastRoute[tf.Alias] != nil {
-				m := min(len(route), len(e.lastRoute[tf.Alias]))
-				if len(route) != len(e.lastRoute[tf.Alias]) {
-					e.routeChanges.WithLabelValues(tf.Alias, tf.Target, strconv.Itoa(m)).Inc()
-				} else {
-					// m - 1 because if the routes are the same apart from the destination, it's
-					// just the destination that's changed, and that's recorded separately below
-					for i := 0; i < (m - 1); i++ {
-						if !reflect.DeepEqual(route[i], e.lastRoute[tf.Alias][i]) {
-							e.routeChanges.WithLabelValues(tf.Alias, tf.Target, strconv.Itoa(i)).Inc()
-						}
-					}
+			for i := 0; i < n; i++ {
+				if route[i] != e.lastRoute[tf.Alias][i] {
+					e.routeChanges.WithLabelValues(tf.Alias, tf.Target, tf.Protocol, tf.Port, strconv.Itoa(i)).Inc()
+					e.series.add(tf.Alias, []string{tf.Alias, tf.Target, tf.Protocol, tf.Port, strconv.Itoa(i)}, e.routeChanges)
 				}
 			}
-			e.lastRoute[tf.Alias] = route
-			if e.lastDest[tf.Alias] != nil && !reflect.DeepEqual(destination, e.lastDest[tf.Alias]) {
-				e.destinationChanges.WithLabelValues(tf.Alias, tf.Target, e.lastDest[tf.Alias].String(), destination.String()).Inc()
+		}
+	}
+	e.lastRoute[tf.Alias] = route
+	e.recordRoute(labels, hops, tf.Result.Start.Add(tf.Result.Duration))
+	if tf.Detail != "destination" {
+		e.recordASPath(labels, hops)
+	}
+	// the destination is the resolved address of the target, not the last
+	// hop, which is some router if the trace stopped short
+	if destination == nil {
+		return
+	}
+	if e.lastDest[tf.Alias] != nil && !destination.Equal(e.lastDest[tf.Alias]) {
+		labels := []string{tf.Alias, tf.Target, tf.Protocol, tf.Port, e.lastDest[tf.Alias].String(), destination.String()}
+		e.destinationChanges.WithLabelValues(labels...).Inc()
+		e.series.add(tf.Alias, labels, e.destinationChanges)
+	}
+	e.lastDest[tf.Alias] = destination
+}
+
+// processHop updates the per hop metrics, for detail hops only the gauges of
+// the last trace. It returns the number of addresses rejected because of
+// max_hop_series. The caller must hold e.mutex.
+func (e *Exporter) processHop(tf *TargetFeedback, host *Hop, effectiveLoss float64, gauges traceGauges) int {
+	full := tf.Detail == "full"
+	rejected := 0
+	hopLabels := []string{tf.Alias, tf.Target, tf.Protocol, tf.Port, strconv.Itoa(host.Hop)}
+	gauges.set(e.responders, float64(len(host.Responders)), hopLabels...)
+
+	// sent, dropped and lost packets can't be attributed to a responder,
+	// they are recorded for the responder that answered most of the probes.
+	// So are received packets and latencies if the engine doesn't tell
+	// which responder sent them.
+	lvs := append(hopLabels, host.IP.String())
+	if !e.allowHopSeries(lvs) {
+		if !full || len(host.Responders) == 0 {
+			// otherwise counted with the responders below
+			rejected++
+		}
+	} else {
+		gauges.set(e.hopLoss, host.LostPercent, lvs...)
+		gauges.set(e.hopEffectiveLoss, effectiveLoss, lvs...)
+		if host.Name != "" {
+			gauges.set(e.hopInfo, 1, append(lvs, host.Name)...)
+		}
+		if host.ASN != "" {
+			gauges.set(e.hopASN, 1, append(lvs, host.ASN, host.ASOrg)...)
+		}
+		if host.Geo != nil {
+			gauges.set(e.hopGeo, 1, append(lvs, host.Geo.labelValues()...)...)
+		}
+		if len(host.MPLS) > 0 {
+			gauges.set(e.hopMPLS, 1, append(lvs, mplsStack(host.MPLS))...)
+		}
+		if host.RTTFloor > 0 {
+			gauges.set(e.hopRTTFloor, host.RTTFloor/1e6, lvs...)
+		}
+		if host.Inflation > 0 {
+			gauges.set(e.hopInflation, host.Inflation, lvs...)
+		}
+		if host.Received > 0 {
+			gauges.set(e.hopLatency, host.Mean/1e6, lvs...)
+		}
+		if full {
+			e.series.add(tf.Alias, lvs, e.sent, e.dropped, e.lost)
+			e.sent.WithLabelValues(lvs...).Add(float64(host.Sent))
+			e.dropped.WithLabelValues(lvs...).Add(float64(host.Dropped))
+			e.lost.WithLabelValues(lvs...).Add(host.LostPercent * float64(host.Sent))
+			if host.Received > 0 {
+				gauges.set(e.best, float64(host.Best)/1e6, lvs...)
+				gauges.set(e.worst, float64(host.Worst)/1e6, lvs...)
+				gauges.set(e.stddev, host.StandardDev/1e6, lvs...)
+				gauges.set(e.jitterMean, host.MeanJitter/1e6, lvs...)
+				gauges.set(e.jitterWorst, float64(host.WorstJitter)/1e6, lvs...)
+				// the interarrival jitter is kept scaled by 16 as in RFC 3550 A.8
+				gauges.set(e.jitterInterarrival, float64(host.InterarrivalJitter)/16/1e6, lvs...)
+			}
+			if !host.attributed() {
+				e.series.add(tf.Alias, lvs, e.received, e.latency)
+				e.received.WithLabelValues(lvs...).Add(float64(host.Received))
+				observeLatency(e.latency.WithLabelValues(lvs...), &Responder{
+					PacketMicrosecs: host.PacketMicrosecs,
+					Received:        host.Received,
+					Mean:            host.Mean,
+				})
 			}
-			e.lastDest[tf.Alias] = destination
 		}
 	}
+	if !full {
+		return rejected
+	}
+
+	for _, r := range host.Responders {
+		lvs := append(hopLabels, r.IP.String())
+		if !e.allowHopSeries(lvs) {
+			rejected++
+			continue
+		}
+		if r.Received > 0 {
+			e.series.add(tf.Alias, lvs, e.received, e.latency)
+			e.received.WithLabelValues(lvs...).Add(float64(r.Received))
+			observeLatency(e.latency.WithLabelValues(lvs...), r)
+		}
+		if r.Name != "" {
+			gauges.set(e.hopInfo, 1, append(lvs, r.Name)...)
+		}
+		if r.ASN != "" {
+			gauges.set(e.hopASN, 1, append(lvs, r.ASN, r.ASOrg)...)
+		}
+		if r.Geo != nil {
+			gauges.set(e.hopGeo, 1, append(lvs, r.Geo.labelValues()...)...)
+		}
+		if len(r.MPLS) > 0 {
+			gauges.set(e.hopMPLS, 1, append(lvs, mplsStack(r.MPLS))...)
+		}
+	}
+	return rejected
+}
+
+// allowHopSeries reports whether the series of a hop address may be written
+// without exceeding max_hop_series. Existing series are always written. The
+// caller must hold e.mutex.
+func (e *Exporter) allowHopSeries(lvs []string) bool {
+	max := currentConfig().MaxHopSeries
+	if max == 0 || e.series.has(lvs[0], lvs, e.received, e.hopLoss) {
+		return true
+	}
+	return e.series.count(lvs[0], e.received, e.hopLoss) < max
+}
+
+// expireSeries deletes the per hop series of the host that were not written
+// within series_ttl or series_ttl_traces and updates the number of series of
+// the host. The caller must hold e.mutex.
+func (e *Exporter) expireSeries(labels []string) {
+	c := currentConfig()
+	alias := labels[0]
+	e.series.expire(alias, c.SeriesTTL, c.SeriesTTLTraces,
+		e.sent, e.received, e.dropped, e.lost, e.latency, e.responders,
+		e.best, e.worst, e.stddev, e.jitterMean, e.jitterWorst, e.jitterInterarrival,
+		e.hopLoss, e.hopEffectiveLoss, e.hopLatency, e.hopInfo, e.hopASN, e.hopGeo, e.hopMPLS, e.hopRTTFloor, e.hopInflation)
+	e.series.add(alias, labels, e.seriesCount)
+	e.seriesCount.WithLabelValues(labels...).Set(float64(e.series.count(alias)))
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
@@ -224,37 +958,101 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.received.Collect(ch)
 	e.dropped.Collect(ch)
 	e.lost.Collect(ch)
-	e.latency.Collect(ch)
+	e.mutex.Lock()
+	latency := e.latency
+	e.mutex.Unlock()
+	latency.Collect(ch)
 	e.routeChanges.Collect(ch)
 	e.destinationChanges.Collect(ch)
+	e.routeInfo.Collect(ch)
 	e.failed.Collect(ch)
+	e.traceDuration.Collect(ch)
+	e.cycles.Collect(ch)
+	e.responders.Collect(ch)
+	e.best.Collect(ch)
+	e.worst.Collect(ch)
+	e.stddev.Collect(ch)
+	e.jitterMean.Collect(ch)
+	e.jitterWorst.Collect(ch)
+	e.jitterInterarrival.Collect(ch)
+	e.hopLoss.Collect(ch)
+	e.hopLatency.Collect(ch)
+	e.hopEffectiveLoss.Collect(ch)
+	e.lossOrigin.Collect(ch)
+	e.hopInfo.Collect(ch)
+	e.hopASN.Collect(ch)
+	e.asPathInfo.Collect(ch)
+	e.asPathChanges.Collect(ch)
+	e.asnRanges.Collect(ch)
+	e.hopGeo.Collect(ch)
+	e.hopMPLS.Collect(ch)
+	e.hopRTTFloor.Collect(ch)
+	e.hopInflation.Collect(ch)
+	e.geoRanges.Collect(ch)
+	e.traceHops.Collect(ch)
+	e.traceResponding.Collect(ch)
+	e.destLoss.Collect(ch)
+	e.destLatency.Collect(ch)
+	e.destReached.Collect(ch)
+	e.destSent.Collect(ch)
+	e.destReceived.Collect(ch)
+	e.destUnreachable.Collect(ch)
+	e.traceTimestamp.Collect(ch)
+	e.seriesCount.Collect(ch)
+	e.seriesRejected.Collect(ch)
+	e.reloadSuccess.Collect(ch)
+	e.reloadSeconds.Collect(ch)
 	return
 }
 
-func trace(host Host, results chan<- *TargetFeedback) error {
-	// run MTR and wait for it to complete
-	a := mtr.New(1, host.Name, config.Arguments...)
-	<-a.Done
-
-	// output result
-	if a.Error == nil {
-		results <- &TargetFeedback{
-			Target: host.Name,
-			Alias:  host.Alias,
-			Hosts:  a.Hosts,
-		}
+// trace runs a single trace of the host, limited by the host's timeout
+func (e *Exporter) trace(ctx context.Context, host Host) *TargetFeedback {
+	if timeout := host.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result, err := probe(ctx, e.probers, host)
+	enrichASN(result)
+	enrichGeo(result)
+	return &TargetFeedback{
+		Target:   host.Name,
+		Alias:    host.Alias,
+		Protocol: host.protocol(),
+		Port:     host.portLabel(),
+		Detail:   host.detail(),
+		Result:   result,
+		Err:      err,
+		Duration: time.Since(start),
 	}
-	return a.Error
 }
 
-func worker(id int, host Host, results chan<- *TargetFeedback, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	return trace(host, results)
+// worker runs a trace as soon as less than max_concurrency traces are running
+func (e *Exporter) worker(w *targetWorker) *TargetFeedback {
+	e.mutex.Lock()
+	semaphore := e.semaphore
+	e.mutex.Unlock()
+
+	if semaphore != nil {
+		select {
+		case semaphore <- struct{}{}:
+			defer func() { <-semaphore }()
+		case <-w.ctx.Done():
+			return &TargetFeedback{Err: w.ctx.Err(), worker: w.id}
+		}
+	}
+
+	tf := e.trace(w.ctx, w.host)
+	tf.worker = w.id
+	return tf
 }
 
 func main() {
 	var (
 		configFile    = flag.String("config.file", "mtr.yaml", "MTR exporter configuration file.")
+		stateDir      = flag.String("state.dir", "", "Directory to keep the route change state in across restarts, disabled if empty.")
 		listenAddress = flag.String("web.listen-address", ":9116", "The address to listen on for HTTP requests.")
 		showVersion   = flag.Bool("version", false, "Print version information.")
 	)
@@ -269,30 +1067,65 @@ func main() {
 	log.Infoln("Starting mtr_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	yamlFile, err := ioutil.ReadFile(*configFile)
-
+	c, err := loadConfig(*configFile)
 	if err != nil {
-		log.Fatalf("Error reading config file: %s", err)
+		log.Fatalf("Error loading config: %s", err)
 	}
-
-	err = yaml.Unmarshal(yamlFile, &config)
+	setConfig(c)
+	db, err := loadASNDatabase(c.ASNDatabase)
+	if err != nil {
+		log.Fatalf("Error loading ASN database: %s", err)
+	}
+	setASNDatabase(db)
+	geo, err := loadGeoDatabase(c.GeoIPDatabase)
 	if err != nil {
-		log.Fatalf("Error parsing config file: %s", err)
+		log.Fatalf("Error loading GeoIP database: %s", err)
 	}
+	setGeoDatabase(geo)
 
 	prometheus.MustRegister(version.NewCollector("mtr_exporter"))
 	exporter := NewExporter()
+	exporter.asnRanges.Set(float64(db.size()))
+	exporter.geoRanges.Set(float64(geo.size()))
+	exporter.reloadSuccess.Set(1)
+	exporter.reloadSeconds.Set(float64(time.Now().Unix()))
 	prometheus.MustRegister(exporter)
 
+	if *stateDir != "" {
+		exporter.stateDir = *stateDir
+		if err := exporter.loadState(); err != nil {
+			log.Fatalf("Error loading state: %s", err)
+		}
+		go exporter.writeStates()
+	}
+
 	go exporter.collect()
 
-	http.Handle("/metrics", prometheus.Handler())
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := exporter.reload(*configFile); err != nil {
+				log.Errorf("Error reloading config: %s", err)
+			} else {
+				log.Infoln("Reloaded config file", *configFile)
+			}
+		}
+	}()
+
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/probe", probeHandler)
+	http.HandleFunc("/-/reload", reloadHandler(exporter, *configFile))
+	http.HandleFunc("/status", exporter.statusHandler)
+	http.HandleFunc("/api/v1/routes/", exporter.routesHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
             <head><title>MTR Exporter</title></head>
             <body>
             <h1>MTR Exporter</h1>
             <p><a href="/metrics">Metrics</a></p>
+            <p><a href="/status">Status</a></p>
+            <p><a href="/probe?target=prometheus.io">Probe prometheus.io</a></p>
             </body>
             </html>`))
 	})
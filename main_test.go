@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// cannedProber returns the results and errors it was given in turn
+type cannedProber struct {
+	results []*TraceResult
+	errs    []error
+}
+
+func (p *cannedProber) Probe(ctx context.Context, host Host) (*TraceResult, error) {
+	result, err := p.results[0], p.errs[0]
+	p.results, p.errs = p.results[1:], p.errs[1:]
+	return result, err
+}
+
+// cannedResult returns the result of a trace of a single cycle in which each
+// hop was answered by the address after 1ms per hop, "" for no reply
+func cannedResult(destination string, ips ...string) *TraceResult {
+	hops := make([]*Hop, len(ips))
+	for i, ip := range ips {
+		hops[i] = newHop(i)
+		if ip != "" {
+			rtt := (i + 1) * 1000
+			hops[i].PacketMicrosecs = []int{rtt}
+			hops[i].responder(net.ParseIP(ip)).PacketMicrosecs = []int{rtt}
+		}
+		hops[i].summarize(1)
+	}
+	result := newTraceResult(hops, time.Now())
+	result.setDestination([]net.IP{net.ParseIP(destination)})
+	return result
+}
+
+var testHost = Host{
+	Name:  "example.com",
+	Alias: "example",
+	Module: Module{
+		Cycles:   1,
+		Protocol: "icmp",
+		Detail:   "full",
+	},
+}
+
+// testLabels are the labels of the trace metrics of testHost
+var testLabels = []string{"example", "example.com", "icmp", ""}
+
+// newTestExporter returns an exporter tracing testHost with the prober
+func newTestExporter(p Prober) *Exporter {
+	setConfig(&Config{Hosts: []Host{testHost}})
+	e := NewExporter()
+	e.probers = map[string]Prober{"mtr": p}
+	e.workers[testHost.Alias] = &targetWorker{host: testHost}
+	return e
+}
+
+// runTrace traces testHost once and processes the result
+func runTrace(e *Exporter) {
+	e.process(e.trace(context.Background(), testHost))
+}
+
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case pb.Counter != nil:
+		return pb.Counter.GetValue()
+	case pb.Gauge != nil:
+		return pb.Gauge.GetValue()
+	}
+	t.Fatalf("unexpected metric %s", pb.String())
+	return 0
+}
+
+func TestProcessSuccess(t *testing.T) {
+	e := newTestExporter(&cannedProber{
+		results: []*TraceResult{cannedResult("93.184.216.34", "192.168.1.1", "", "93.184.216.34")},
+		errs:    []error{nil},
+	})
+	runTrace(e)
+
+	tests := []struct {
+		name   string
+		metric prometheus.Metric
+		want   float64
+	}{
+		{"destination reached", e.destReached.WithLabelValues(testLabels...), 1},
+		{"destination loss", e.destLoss.WithLabelValues(testLabels...), 0},
+		{"destination latency", e.destLatency.WithLabelValues(testLabels...), 0.003},
+		{"destination received", e.destReceived.WithLabelValues(testLabels...), 1},
+		{"hops", e.traceHops.WithLabelValues(testLabels...), 3},
+		{"responding hops", e.traceResponding.WithLabelValues(testLabels...), 2},
+		{"first hop received", e.received.WithLabelValues(append(testLabels, "0", "192.168.1.1")...), 1},
+		{"silent hop loss", e.hopLoss.WithLabelValues(append(testLabels, "1", "<nil>")...), 1},
+		{"silent hop effective loss", e.hopEffectiveLoss.WithLabelValues(append(testLabels, "1", "<nil>")...), 0},
+		{"loss origin", e.lossOrigin.WithLabelValues(testLabels...), -1},
+	}
+	for _, test := range tests {
+		if got := metricValue(t, test.metric); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+	if s := e.status[testHost.Alias]; s == nil || s.Reason != "" {
+		t.Errorf("got status %+v, want a successful trace", s)
+	}
+}
+
+func TestProcessFailure(t *testing.T) {
+	e := newTestExporter(&cannedProber{
+		results: []*TraceResult{nil},
+		errs:    []error{&TraceError{Reason: reasonExit, Err: errors.New("exit status 1")}},
+	})
+	runTrace(e)
+
+	if got := metricValue(t, e.failed.WithLabelValues(append(testLabels, reasonExit)...)); got != 1 {
+		t.Errorf("got %v failed traces, want 1", got)
+	}
+	if got := metricValue(t, e.destSent.WithLabelValues(testLabels...)); got != 0 {
+		t.Errorf("got %v packets sent to the destination, want 0", got)
+	}
+}
+
+func TestProcessEmptyResult(t *testing.T) {
+	for _, result := range []*TraceResult{nil, cannedResult("93.184.216.34")} {
+		e := newTestExporter(&cannedProber{results: []*TraceResult{result}, errs: []error{nil}})
+		runTrace(e)
+		// process must not depend on the prober to reject empty results
+		e.process(&TargetFeedback{Target: testHost.Name, Alias: testHost.Alias, Protocol: "icmp", Result: result})
+
+		if got := metricValue(t, e.failed.WithLabelValues(append(testLabels, reasonNoReply)...)); got != 2 {
+			t.Errorf("got %v failed traces, want 2", got)
+		}
+	}
+}
+
+func TestProcessUnreached(t *testing.T) {
+	e := newTestExporter(&cannedProber{
+		results: []*TraceResult{cannedResult("93.184.216.34", "192.168.1.1", "10.0.0.1", "")},
+		errs:    []error{nil},
+	})
+	runTrace(e)
+
+	tests := []struct {
+		name   string
+		metric prometheus.Metric
+		want   float64
+	}{
+		{"destination reached", e.destReached.WithLabelValues(testLabels...), 0},
+		{"destination loss", e.destLoss.WithLabelValues(testLabels...), 1},
+		{"destination unreachable", e.destUnreachable.WithLabelValues(testLabels...), 1},
+		{"destination sent", e.destSent.WithLabelValues(testLabels...), 1},
+		{"destination received", e.destReceived.WithLabelValues(testLabels...), 0},
+		{"loss origin", e.lossOrigin.WithLabelValues(testLabels...), 2},
+	}
+	for _, test := range tests {
+		if got := metricValue(t, test.metric); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProcessRouteChange(t *testing.T) {
+	e := newTestExporter(&cannedProber{
+		results: []*TraceResult{
+			cannedResult("93.184.216.34", "192.168.1.1", "10.0.0.1", "93.184.216.34"),
+			cannedResult("93.184.216.34", "192.168.1.1", "10.0.0.1", "93.184.216.34"),
+			cannedResult("93.184.216.34", "192.168.1.1", "10.0.0.2", "93.184.216.34"),
+			cannedResult("93.184.216.34", "192.168.1.1", "10.0.0.2", "10.0.0.3", "93.184.216.34"),
+		},
+		errs: []error{nil, nil, nil, nil},
+	})
+	changes := func(hop string) float64 {
+		return metricValue(t, e.routeChanges.WithLabelValues(append(testLabels, hop)...))
+	}
+
+	runTrace(e)
+	runTrace(e)
+	if got := changes("1"); got != 0 {
+		t.Errorf("same route: got %v changes, want 0", got)
+	}
+	runTrace(e)
+	if got := changes("1"); got != 1 {
+		t.Errorf("changed hop: got %v changes, want 1", got)
+	}
+	// a longer route counts as a change after the hops both have in common
+	runTrace(e)
+	if got := changes("3"); got != 1 {
+		t.Errorf("longer route: got %v changes, want 1", got)
+	}
+	if got := changes("1"); got != 1 {
+		t.Errorf("longer route: got %v changes of the second hop, want still 1", got)
+	}
+}
+
+func TestProcessStaleWorker(t *testing.T) {
+	e := newTestExporter(&cannedProber{
+		results: []*TraceResult{cannedResult("93.184.216.34", "93.184.216.34")},
+		errs:    []error{nil},
+	})
+	tf := e.trace(context.Background(), testHost)
+	// the host was reconfigured while it was traced
+	e.workers[testHost.Alias] = &targetWorker{id: 1, host: testHost}
+	e.process(tf)
+
+	if got := metricValue(t, e.destSent.WithLabelValues(testLabels...)); got != 0 {
+		t.Errorf("got %v packets sent to the destination, want 0", got)
+	}
+}
+
+func TestProcessHopChurn(t *testing.T) {
+	host := testHost
+	host.Detail = "hops"
+	p := &cannedProber{}
+	for i := 0; i < 5; i++ {
+		// the second hop answers from another address each trace
+		p.results = append(p.results, cannedResult("93.184.216.34", "192.168.1.1", fmt.Sprintf("10.0.0.%d", i+1), "93.184.216.34"))
+		p.errs = append(p.errs, nil)
+	}
+	setConfig(&Config{Hosts: []Host{host}, MaxHopSeries: 4})
+	e := NewExporter()
+	e.probers = map[string]Prober{"mtr": p}
+	e.workers[host.Alias] = &targetWorker{host: host}
+	for i := 0; i < 5; i++ {
+		e.process(e.trace(context.Background(), host))
+	}
+
+	// the gauges of the addresses of earlier traces are gone and must not
+	// count against max_hop_series
+	if got := e.series.count(host.Alias, e.received, e.hopLoss); got != 3 {
+		t.Errorf("got %d hop series, want 3", got)
+	}
+	if got := metricValue(t, e.seriesRejected.WithLabelValues(testLabels...)); got != 0 {
+		t.Errorf("got %v rejected series, want 0", got)
+	}
+	if got := metricValue(t, e.hopLoss.WithLabelValues(append(testLabels, "1", "10.0.0.5")...)); got != 0 {
+		t.Errorf("got loss %v of the last address of the second hop, want 0", got)
+	}
+	if e.hopLoss.DeleteLabelValues(append(testLabels, "1", "10.0.0.4")...) {
+		t.Error("got the loss of an earlier address of the second hop")
+	}
+}
+
+func TestProcessHopInfo(t *testing.T) {
+	for _, detail := range []string{"hops", "full"} {
+		host := testHost
+		host.Detail = detail
+		result := cannedResult("93.184.216.34", "192.168.1.1", "93.184.216.34")
+		result.Hops[0].Name = "router.lan"
+		result.Hops[0].Responders[0].Name = "router.lan"
+		setConfig(&Config{Hosts: []Host{host}})
+		e := NewExporter()
+		e.probers = map[string]Prober{"mtr": &cannedProber{results: []*TraceResult{result}, errs: []error{nil}}}
+		e.workers[host.Alias] = &targetWorker{host: host}
+		e.process(e.trace(context.Background(), host))
+
+		if !e.hopInfo.DeleteLabelValues(append(testLabels, "0", "192.168.1.1", "router.lan")...) {
+			t.Errorf("detail %s: got no name of the first hop", detail)
+		}
+	}
+}
+
+func TestNativeLatencyHistogram(t *testing.T) {
+	for _, factor := range []float64{0, 1.1} {
+		h := newLatencyHistogram(nil, factor)
+		observeLatency(h.WithLabelValues(append(testLabels, "0", "192.0.2.1")...), &Responder{PacketMicrosecs: []int{1000, 2000, 40000}})
+
+		var pb dto.Metric
+		if err := h.WithLabelValues(append(testLabels, "0", "192.0.2.1")...).(prometheus.Metric).Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if pb.Histogram.GetSampleCount() != 3 || len(pb.Histogram.Bucket) != len(defaultLatencyBuckets) {
+			t.Errorf("factor %v: got %d samples in %d classic buckets, want 3 in %d",
+				factor, pb.Histogram.GetSampleCount(), len(pb.Histogram.Bucket), len(defaultLatencyBuckets))
+		}
+		native := len(pb.Histogram.PositiveSpan) > 0
+		if native != (factor > 0) {
+			t.Errorf("factor %v: got native buckets %v", factor, native)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDNSCacheTTL is how long reverse lookups are cached if dns_cache_ttl is not set
+const defaultDNSCacheTTL = time.Hour
+
+// nameCache caches reverse DNS lookups of hop addresses, so engines that
+// don't resolve names themselves don't look up every hop on every trace
+type nameCache struct {
+	mutex     sync.Mutex
+	entries   map[string]nameEntry
+	lastSweep time.Time
+}
+
+type nameEntry struct {
+	name    string
+	expires time.Time
+}
+
+// names is the cache used for all hosts
+var names = &nameCache{entries: make(map[string]nameEntry)}
+
+// lookup returns the name of the address, an empty name if it has none.
+// Names and missing names are cached for ttl, temporary failures and lookups
+// cut short by ctx are not.
+func (c *nameCache) lookup(ctx context.Context, ip net.IP, ttl time.Duration) string {
+	key := ip.String()
+	now := time.Now()
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.name
+	}
+
+	name := ""
+	results, err := net.DefaultResolver.LookupAddr(ctx, key)
+	if err == nil && len(results) > 0 {
+		name = strings.TrimSuffix(results[0], ".")
+	}
+	if ctx.Err() != nil {
+		return name
+	}
+	if dnsErr, ok := err.(*net.DNSError); ok && (dnsErr.IsTemporary || dnsErr.IsTimeout) {
+		return name
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = nameEntry{name: name, expires: now.Add(ttl)}
+	if now.Sub(c.lastSweep) > ttl {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	return name
+}
+
+// dnsCacheTTL returns how long reverse lookups are cached
+func dnsCacheTTL() time.Duration {
+	if ttl := currentConfig().DNSCacheTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultDNSCacheTTL
+}
+
+// resolveNames sets the host names of all responders by cached reverse DNS
+// lookups. The responders are looked up in parallel, so a trace doesn't wait
+// for one slow lookup after the other, and no longer than ctx allows.
+func resolveNames(ctx context.Context, hosts []*Hop) {
+	ttl := dnsCacheTTL()
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		for _, r := range host.Responders {
+			wg.Add(1)
+			go func(r *Responder) {
+				defer wg.Done()
+				r.Name = names.lookup(ctx, r.IP, ttl)
+			}(r)
+		}
+	}
+	wg.Wait()
+
+	for _, host := range hosts {
+		for _, r := range host.Responders {
+			if r.IP.Equal(host.IP) {
+				host.Name = r.Name
+			}
+		}
+	}
+}
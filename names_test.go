@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResolveNamesCached(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	names.mutex.Lock()
+	names.entries["192.0.2.1"] = nameEntry{name: "router.example.net", expires: expires}
+	names.entries["192.0.2.2"] = nameEntry{name: "other.example.net", expires: expires}
+	names.entries["192.0.2.3"] = nameEntry{expires: expires}
+	names.mutex.Unlock()
+
+	hop := newHop(0)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		hop.responder(net.ParseIP(ip)).PacketMicrosecs = []int{1000}
+	}
+	hop.PacketMicrosecs = []int{1000, 1000}
+	hop.summarize(2)
+	silent := newHop(1)
+	silent.responder(net.ParseIP("192.0.2.3"))
+	silent.summarize(2)
+
+	// cached names need no lookup, even with the trace context done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resolveNames(ctx, []*Hop{hop, silent})
+
+	if hop.Name != "router.example.net" {
+		t.Errorf("got hop name %q, want router.example.net", hop.Name)
+	}
+	if hop.Responders[1].Name != "other.example.net" {
+		t.Errorf("got responder name %q, want other.example.net", hop.Responders[1].Name)
+	}
+	if silent.Responders[0].Name != "" {
+		t.Errorf("got name %q of an address without name", silent.Responders[0].Name)
+	}
+}
+
+func TestNameCacheCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &nameCache{entries: make(map[string]nameEntry)}
+	if name := c.lookup(ctx, net.ParseIP("192.0.2.10"), time.Hour); name != "" {
+		t.Errorf("got name %q of a cancelled lookup", name)
+	}
+	if _, ok := c.entries["192.0.2.10"]; ok {
+		t.Errorf("cancelled lookup was cached")
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// nativeMaxHops is the highest TTL the native engine probes, like mtr's default
+	nativeMaxHops = 30
+
+	// nativeDefaultInterval is the time between two cycles, like mtr's default
+	nativeDefaultInterval = time.Second
+
+	// default destination ports, the UDP port is incremented per TTL like traceroute does
+	nativeDefaultUDPPort = 33434
+	nativeDefaultTCPPort = 80
+
+	// nativeMinPacketSize is the size of the ICMP, UDP or TCP header
+	nativeMinPacketSize = 8
+)
+
+// errReceiveTimeout is returned by probeConn.receive once the deadline passed
+var errReceiveTimeout = errors.New("receive timeout")
+
+// probeConn sends probes and reads the replies they triggered. Implementations
+// own the sockets, the tracing logic only deals with sequence numbers, so it
+// can be tested with a fake conn.
+type probeConn interface {
+	// send sends probe number seq with the given TTL to the destination
+	send(seq int, ttl int) error
+	// receive returns the next reply or errReceiveTimeout once the deadline passed
+	receive(deadline time.Time) (*probeReply, error)
+	// endCycle releases what the probes of a cycle needed once its replies are in
+	endCycle()
+	close() error
+}
+
+// probeReply is an answer to the probe with the sequence number seq
+type probeReply struct {
+	seq  int
+	from net.IP
+	// final is set if the reply came from the destination itself
+	final    bool
+	received time.Time
+}
+
+// nativeOptions are the settings of a native trace
+type nativeOptions struct {
+	destination net.IP
+	protocol    string
+	port        int
+	packetSize  int
+	cycles      int
+	interval    time.Duration
+	maxHops     int
+}
+
+// nativeTrace probes all TTLs up to the destination once per cycle and
+// returns the per hop results
+func nativeTrace(ctx context.Context, conn probeConn, opts nativeOptions) ([]*Hop, error) {
+	var (
+		hosts   = make([]*Hop, opts.maxHops)
+		sentAt  = make(map[int]time.Time)
+		lastHop = opts.maxHops
+	)
+	for i := range hosts {
+		hosts[i] = newHop(i)
+	}
+
+	for cycle := 0; cycle < opts.cycles; cycle++ {
+		start := time.Now()
+		deadline := start.Add(opts.interval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+
+		pending := 0
+		for ttl := 1; ttl <= lastHop; ttl++ {
+			seq := cycle*opts.maxHops + ttl - 1
+			sentAt[seq] = time.Now()
+			if err := conn.send(seq, ttl); err != nil {
+				return nil, fmt.Errorf("failed to send probe with ttl %d: %s", ttl, err)
+			}
+			pending++
+		}
+
+		for pending > 0 {
+			reply, err := conn.receive(deadline)
+			if err == errReceiveTimeout {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			sent, ok := sentAt[reply.seq]
+			if !ok {
+				// unknown or duplicate reply
+				continue
+			}
+			delete(sentAt, reply.seq)
+			if reply.seq/opts.maxHops == cycle {
+				pending--
+			}
+
+			hop := reply.seq % opts.maxHops
+			rtt := int(reply.received.Sub(sent) / time.Microsecond)
+			r := hosts[hop].responder(reply.from)
+			r.PacketMicrosecs = append(r.PacketMicrosecs, rtt)
+			hosts[hop].IP = reply.from
+			hosts[hop].PacketMicrosecs = append(hosts[hop].PacketMicrosecs, rtt)
+			if reply.final && hop+1 < lastHop {
+				lastHop = hop + 1
+			}
+		}
+
+		// wait for the rest of the interval before starting the next cycle
+		if cycle < opts.cycles-1 {
+			timer := time.NewTimer(deadline.Sub(time.Now()))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+		conn.endCycle()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("native trace did not finish: %w", ctx.Err())
+		}
+	}
+
+	// cut off the hops behind the destination or the last hop that answered
+	for lastHop > 0 && hosts[lastHop-1].IP == nil {
+		lastHop--
+	}
+	if lastHop == 0 {
+		return nil, errNoReply
+	}
+	hosts = hosts[:lastHop]
+	for _, host := range hosts {
+		host.summarize(opts.cycles)
+	}
+	return hosts, nil
+}
+
+// nativeProber traces in-process instead of running mtr
+type nativeProber struct{}
+
+func (nativeProber) Probe(ctx context.Context, host Host) (*TraceResult, error) {
+	start := time.Now()
+	s := host.settings()
+	dst, err := resolveTarget(ctx, host.Name, s.AddressFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := nativeOptions{
+		destination: dst,
+		protocol:    s.Protocol,
+		port:        s.Port,
+		packetSize:  s.PacketSize,
+		cycles:      s.Cycles,
+		interval:    nativeDefaultInterval,
+		maxHops:     nativeMaxHops,
+	}
+	if s.PacketInterval > 0 {
+		opts.interval = time.Duration(s.PacketInterval * float64(time.Second))
+	}
+	if opts.packetSize < nativeMinPacketSize {
+		opts.packetSize = nativeMinPacketSize
+	}
+
+	conn, err := newProbeConn(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	hosts, err := nativeTrace(ctx, conn, opts)
+	if err != nil {
+		return nil, err
+	}
+	if s.DNS == nil || *s.DNS {
+		resolveNames(ctx, hosts)
+	}
+	result := newTraceResult(hosts, start)
+	result.setDestination([]net.IP{dst})
+	return result, nil
+}
+
+// ICMP message types, IP protocol numbers and header sizes used by the native engine
+const (
+	icmpv4EchoReply       = 0
+	icmpv4DestUnreachable = 3
+	icmpv4Echo            = 8
+	icmpv4TimeExceeded    = 11
+	icmpv6DestUnreachable = 1
+	icmpv6TimeExceeded    = 3
+	icmpv6Echo            = 128
+	icmpv6EchoReply       = 129
+
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+
+	ipv4HeaderMinLen = 20
+	ipv6HeaderLen    = 40
+	icmpHeaderLen    = 8
+)
+
+// probeKey identifies a probe on the wire: the ICMP sequence number for
+// ICMP probes, the source and destination port for UDP and TCP probes
+type probeKey struct {
+	proto int
+	id    int
+	port  int
+}
+
+// replyQueue is shared by the socket implementations of probeConn. It maps
+// probes on the wire to sequence numbers and hands replies to receive.
+type replyQueue struct {
+	mutex  sync.Mutex
+	probes map[probeKey]int
+	// ports are the keys of the UDP and TCP probes of the current cycle,
+	// which are released along with their sockets
+	ports   []probeKey
+	replies chan *probeReply
+	done    chan struct{}
+}
+
+func newReplyQueue() replyQueue {
+	return replyQueue{
+		probes:  make(map[probeKey]int),
+		replies: make(chan *probeReply, nativeMaxHops),
+		done:    make(chan struct{}),
+	}
+}
+
+func (q *replyQueue) register(key probeKey, seq int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.probes[key] = seq
+}
+
+// registerPort registers a UDP or TCP probe until releasePorts is called
+func (q *replyQueue) registerPort(key probeKey, seq int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.probes[key] = seq
+	q.ports = append(q.ports, key)
+}
+
+// releasePorts forgets the probes registered by registerPort. Once a socket
+// is closed its port can be taken by another trace or process, whose replies
+// must not be taken for those of the probe.
+func (q *replyQueue) releasePorts() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, key := range q.ports {
+		delete(q.probes, key)
+	}
+	q.ports = nil
+}
+
+func (q *replyQueue) lookup(key probeKey) (int, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	seq, ok := q.probes[key]
+	return seq, ok
+}
+
+// deliver queues a reply, it gives up once the conn is closed
+func (q *replyQueue) deliver(r *probeReply) {
+	select {
+	case q.replies <- r:
+	case <-q.done:
+	}
+}
+
+func (q *replyQueue) receive(deadline time.Time) (*probeReply, error) {
+	timer := time.NewTimer(deadline.Sub(time.Now()))
+	defer timer.Stop()
+	select {
+	case r := <-q.replies:
+		return r, nil
+	case <-timer.C:
+		return nil, errReceiveTimeout
+	}
+}
+
+// icmpEcho builds an ICMP echo request of the given size
+func icmpEcho(ipv6 bool, id int, seq int, size int) []byte {
+	b := make([]byte, size)
+	b[0] = icmpv4Echo
+	if ipv6 {
+		// the kernel calculates the checksum for ICMPv6
+		b[0] = icmpv6Echo
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(id))
+	binary.BigEndian.PutUint16(b[6:], uint16(seq))
+	if !ipv6 {
+		binary.BigEndian.PutUint16(b[2:], checksum(b))
+	}
+	return b
+}
+
+// checksum is the internet checksum of RFC 1071
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// icmpMessage is the part of a received ICMP message needed to match it to a probe
+type icmpMessage struct {
+	// echoReply is set for echo replies, id and seq are then those of the reply
+	echoReply bool
+	// timeExceeded and unreachable are errors, proto, dst and the ports or
+	// id and seq are then taken from the embedded original packet
+	timeExceeded bool
+	unreachable  bool
+	proto        int
+	dst          net.IP
+	id           int
+	seq          int
+	srcPort      int
+	dstPort      int
+}
+
+// parseICMP parses an ICMP message without IP header
+func parseICMP(ipv6 bool, b []byte) (*icmpMessage, error) {
+	if len(b) < icmpHeaderLen {
+		return nil, fmt.Errorf("ICMP message too short: %d bytes", len(b))
+	}
+	m := &icmpMessage{}
+	switch {
+	case !ipv6 && b[0] == icmpv4EchoReply, ipv6 && b[0] == icmpv6EchoReply:
+		m.echoReply = true
+		m.id = int(binary.BigEndian.Uint16(b[4:]))
+		m.seq = int(binary.BigEndian.Uint16(b[6:]))
+		return m, nil
+	case !ipv6 && b[0] == icmpv4TimeExceeded, ipv6 && b[0] == icmpv6TimeExceeded:
+		m.timeExceeded = true
+	case !ipv6 && b[0] == icmpv4DestUnreachable, ipv6 && b[0] == icmpv6DestUnreachable:
+		m.unreachable = true
+	default:
+		return nil, fmt.Errorf("unexpected ICMP type %d", b[0])
+	}
+
+	// the original IP header follows the ICMP header
+	inner := b[icmpHeaderLen:]
+	var payload []byte
+	if ipv6 {
+		if len(inner) < ipv6HeaderLen {
+			return nil, fmt.Errorf("embedded IPv6 header too short: %d bytes", len(inner))
+		}
+		m.proto = int(inner[6])
+		m.dst = net.IP(append([]byte{}, inner[24:40]...))
+		payload = inner[ipv6HeaderLen:]
+	} else {
+		if len(inner) < ipv4HeaderMinLen {
+			return nil, fmt.Errorf("embedded IPv4 header too short: %d bytes", len(inner))
+		}
+		ihl := int(inner[0]&0x0f) * 4
+		if len(inner) < ihl {
+			return nil, fmt.Errorf("embedded IPv4 header too short: %d bytes", len(inner))
+		}
+		m.proto = int(inner[9])
+		m.dst = net.IP(append([]byte{}, inner[16:20]...))
+		payload = inner[ihl:]
+	}
+	if len(payload) < icmpHeaderLen {
+		return nil, fmt.Errorf("embedded payload too short: %d bytes", len(payload))
+	}
+
+	switch m.proto {
+	case protocolICMP, protocolICMPv6:
+		m.id = int(binary.BigEndian.Uint16(payload[4:]))
+		m.seq = int(binary.BigEndian.Uint16(payload[6:]))
+	case protocolUDP, protocolTCP:
+		m.srcPort = int(binary.BigEndian.Uint16(payload[0:]))
+		m.dstPort = int(binary.BigEndian.Uint16(payload[2:]))
+	}
+	return m, nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// origins of extended socket errors, see linux/errqueue.h
+const (
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+)
+
+// newProbeConn opens a raw ICMP socket, which works for all protocols but
+// needs root or CAP_NET_RAW. ICMP probes fall back to an unprivileged ICMP
+// socket if the raw socket is not permitted.
+func newProbeConn(opts nativeOptions) (probeConn, error) {
+	conn, err := newRawConn(opts)
+	if err != nil && opts.protocol == "icmp" && errors.Is(err, os.ErrPermission) {
+		return newPingConn(opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raw ICMP socket, %s probes need root or CAP_NET_RAW: %w", opts.protocol, err)
+	}
+	return conn, nil
+}
+
+// setTTL sets the TTL or hop limit of all packets sent on the socket
+func setTTL(c syscall.Conn, ipv6 bool, ttl int) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return setTTLRaw(rc, ipv6, ttl)
+}
+
+func setTTLRaw(rc syscall.RawConn, ipv6 bool, ttl int) error {
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		err = setTTLFd(int(fd), ipv6, ttl)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func setTTLFd(fd int, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// icmpIDs hands out the ICMP identifiers of raw conns. Every raw ICMP socket
+// receives all replies, so concurrent traces tell theirs apart by identifier.
+var icmpIDs = uint32(os.Getpid())
+
+// rawConn sends ICMP echo requests, UDP datagrams or TCP SYNs and reads the
+// replies from a raw ICMP socket
+type rawConn struct {
+	replyQueue
+	opts    nativeOptions
+	ipv6    bool
+	id      int
+	icmp    net.PacketConn
+	sockets []io.Closer
+}
+
+func newRawConn(opts nativeOptions) (*rawConn, error) {
+	ipv6 := opts.destination.To4() == nil
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	icmp, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &rawConn{
+		replyQueue: newReplyQueue(),
+		opts:       opts,
+		ipv6:       ipv6,
+		id:         int(atomic.AddUint32(&icmpIDs, 1) & 0xffff),
+		icmp:       icmp,
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *rawConn) read() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := c.icmp.ReadFrom(buf)
+		if err != nil {
+			// the socket was closed
+			return
+		}
+		received := time.Now()
+
+		m, err := parseICMP(c.ipv6, buf[:n])
+		if err != nil {
+			continue
+		}
+		key, ok := c.probeKey(m)
+		if !ok {
+			continue
+		}
+		seq, ok := c.lookup(key)
+		if !ok {
+			continue
+		}
+
+		from := addr.(*net.IPAddr).IP
+		c.deliver(&probeReply{
+			seq:      seq,
+			from:     from,
+			final:    m.echoReply || from.Equal(c.opts.destination),
+			received: received,
+		})
+	}
+}
+
+// probeKey returns the key of the probe an ICMP message answers. The socket
+// receives all ICMP messages of the host, errors only answer a probe if the
+// embedded packet was sent to the destination, and to the port of the probe.
+func (c *rawConn) probeKey(m *icmpMessage) (probeKey, bool) {
+	if !m.echoReply && !m.dst.Equal(c.opts.destination) {
+		return probeKey{}, false
+	}
+	switch {
+	case m.echoReply, m.proto == protocolICMP, m.proto == protocolICMPv6:
+		if m.id != c.id {
+			// someone else's ping
+			return probeKey{}, false
+		}
+		return probeKey{proto: protocolICMP, id: m.seq}, true
+	case m.proto == protocolUDP, m.proto == protocolTCP:
+		return probeKey{proto: m.proto, id: m.srcPort, port: m.dstPort}, true
+	}
+	return probeKey{}, false
+}
+
+func (c *rawConn) send(seq int, ttl int) error {
+	switch c.opts.protocol {
+	case "udp":
+		return c.sendUDP(seq, ttl)
+	case "tcp":
+		return c.sendTCP(seq, ttl)
+	}
+
+	if err := setTTL(c.icmp.(syscall.Conn), c.ipv6, ttl); err != nil {
+		return err
+	}
+	c.register(probeKey{proto: protocolICMP, id: seq & 0xffff}, seq)
+	_, err := c.icmp.WriteTo(icmpEcho(c.ipv6, c.id, seq, c.opts.packetSize), &net.IPAddr{IP: c.opts.destination})
+	return err
+}
+
+// sendUDP sends each probe from its own socket, so that the source port
+// identifies the probe in the ICMP replies. The sockets are closed at the end
+// of each cycle, the replies are read from the ICMP socket.
+func (c *rawConn) sendUDP(seq int, ttl int) error {
+	network := "udp4"
+	if c.ipv6 {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return err
+	}
+	c.sockets = append(c.sockets, conn)
+	if err := setTTL(conn, c.ipv6, ttl); err != nil {
+		return err
+	}
+
+	port := c.opts.port
+	if port == 0 {
+		port = nativeDefaultUDPPort + ttl - 1
+	}
+	c.registerPort(probeKey{proto: protocolUDP, id: conn.LocalAddr().(*net.UDPAddr).Port, port: port}, seq)
+	_, err = conn.WriteTo(make([]byte, c.opts.packetSize-nativeMinPacketSize), &net.UDPAddr{IP: c.opts.destination, Port: port})
+	return err
+}
+
+// sendTCP connects from a socket bound before the SYN is sent, so that the
+// source port identifies the probe in the ICMP replies. The destination
+// answers with SYN-ACK or RST, which completes the connect.
+func (c *rawConn) sendTCP(seq int, ttl int) error {
+	port := c.opts.port
+	if port == 0 {
+		port = nativeDefaultTCPPort
+	}
+	network := "tcp4"
+	if c.ipv6 {
+		network = "tcp6"
+	}
+	d := net.Dialer{
+		Deadline: time.Now().Add(c.opts.interval),
+		Control: func(network, address string, rc syscall.RawConn) error {
+			var err error
+			cerr := rc.Control(func(fd uintptr) {
+				err = c.prepareTCP(int(fd), seq, ttl, port)
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	go func() {
+		conn, err := d.Dial(network, net.JoinHostPort(c.opts.destination.String(), strconv.Itoa(port)))
+		received := time.Now()
+		if err == nil {
+			conn.Close()
+		}
+		if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+			c.deliver(&probeReply{seq: seq, from: c.opts.destination, final: true, received: received})
+		}
+	}()
+	return nil
+}
+
+func (c *rawConn) prepareTCP(fd int, seq int, ttl int, port int) error {
+	if err := setTTLFd(fd, c.ipv6, ttl); err != nil {
+		return err
+	}
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if c.ipv6 {
+		sa = &syscall.SockaddrInet6{}
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return err
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		c.registerPort(probeKey{proto: protocolTCP, id: sa.Port, port: port}, seq)
+	case *syscall.SockaddrInet6:
+		c.registerPort(probeKey{proto: protocolTCP, id: sa.Port, port: port}, seq)
+	}
+	return nil
+}
+
+func (c *rawConn) endCycle() {
+	c.releasePorts()
+	for _, s := range c.sockets {
+		s.Close()
+	}
+	c.sockets = nil
+}
+
+func (c *rawConn) close() error {
+	close(c.done)
+	c.endCycle()
+	return c.icmp.Close()
+}
+
+// pingConn sends ICMP echo requests over an unprivileged ICMP socket, which
+// is allowed for the groups in the net.ipv4.ping_group_range sysctl. The
+// kernel reports ICMP errors of such sockets on the socket's error queue.
+type pingConn struct {
+	replyQueue
+	opts nativeOptions
+	ipv6 bool
+	conn net.PacketConn
+	raw  syscall.RawConn
+}
+
+func newPingConn(opts nativeOptions) (*pingConn, error) {
+	ipv6 := opts.destination.To4() == nil
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	level, recvErr := syscall.IPPROTO_IP, syscall.IP_RECVERR
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if ipv6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		level, recvErr = syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR
+		sa = &syscall.SockaddrInet6{}
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMP socket, the native engine needs root, CAP_NET_RAW or a net.ipv4.ping_group_range including the exporter's group: %w", err)
+	}
+	if err := syscall.SetsockoptInt(fd, level, recvErr, 1); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	conn, err := net.FilePacketConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &pingConn{
+		replyQueue: newReplyQueue(),
+		opts:       opts,
+		ipv6:       ipv6,
+		conn:       conn,
+		raw:        raw,
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *pingConn) read() {
+	buf := make([]byte, 1500)
+	oob := make([]byte, 512)
+	for {
+		var (
+			n, oobn  int
+			from     syscall.Sockaddr
+			errQueue bool
+			rerr     error
+		)
+		err := c.raw.Read(func(fd uintptr) bool {
+			n, oobn, _, from, rerr = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE)
+			if rerr == nil {
+				errQueue = true
+				return true
+			}
+			n, oobn, _, from, rerr = syscall.Recvmsg(int(fd), buf, oob, 0)
+			return rerr != syscall.EAGAIN
+		})
+		if err != nil {
+			// the socket was closed
+			return
+		}
+		if rerr != nil || n < icmpHeaderLen {
+			continue
+		}
+		received := time.Now()
+
+		// both echo replies and the error queue return an ICMP header with our sequence number
+		seq, ok := c.lookup(probeKey{proto: protocolICMP, id: int(buf[6])<<8 | int(buf[7])})
+		if !ok {
+			continue
+		}
+		reply := &probeReply{seq: seq, received: received}
+		if errQueue {
+			reply.from = c.offender(oob[:oobn])
+			if reply.from == nil {
+				continue
+			}
+			reply.final = reply.from.Equal(c.opts.destination)
+		} else {
+			reply.from = sockaddrIP(from)
+			reply.final = true
+		}
+		c.deliver(reply)
+	}
+}
+
+// offender returns the address of the host that sent the ICMP error from
+// the sock_extended_err control message
+func (c *pingConn) offender(oob []byte) net.IP {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, msg := range msgs {
+		data := msg.Data
+		switch {
+		case !c.ipv6 && msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_RECVERR:
+			// struct sock_extended_err is followed by a struct sockaddr_in
+			if len(data) >= 24 && data[4] == soEEOriginICMP {
+				return net.IP(append([]byte{}, data[20:24]...))
+			}
+		case c.ipv6 && msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == syscall.IPV6_RECVERR:
+			// struct sock_extended_err is followed by a struct sockaddr_in6
+			if len(data) >= 40 && data[4] == soEEOriginICMP6 {
+				return net.IP(append([]byte{}, data[24:40]...))
+			}
+		}
+	}
+	return nil
+}
+
+func sockaddrIP(sa syscall.Sockaddr) net.IP {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(append([]byte{}, sa.Addr[:]...))
+	case *syscall.SockaddrInet6:
+		return net.IP(append([]byte{}, sa.Addr[:]...))
+	}
+	return nil
+}
+
+func (c *pingConn) send(seq int, ttl int) error {
+	if err := setTTLRaw(c.raw, c.ipv6, ttl); err != nil {
+		return err
+	}
+	c.register(probeKey{proto: protocolICMP, id: seq & 0xffff}, seq)
+	// the kernel replaces the identifier with the socket's port
+	_, err := c.conn.WriteTo(icmpEcho(c.ipv6, 0, seq, c.opts.packetSize), &net.UDPAddr{IP: c.opts.destination})
+	return err
+}
+
+func (c *pingConn) endCycle() {}
+
+func (c *pingConn) close() error {
+	close(c.done)
+	return c.conn.Close()
+}
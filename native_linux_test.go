@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRawConnProbeKey(t *testing.T) {
+	c := &rawConn{replyQueue: newReplyQueue(), opts: fakeOptions(1), id: 0x1234}
+	c.register(probeKey{proto: protocolICMP, id: 7}, 7)
+	c.registerPort(probeKey{proto: protocolUDP, id: 40000, port: 33436}, 8)
+	other := net.ParseIP("198.51.100.7")
+
+	tests := []struct {
+		name string
+		m    icmpMessage
+		seq  int
+	}{
+		{"echo reply", icmpMessage{echoReply: true, id: 0x1234, seq: 7}, 7},
+		{"someone else's echo reply", icmpMessage{echoReply: true, id: 0x4321, seq: 7}, -1},
+		{"time exceeded", icmpMessage{timeExceeded: true, proto: protocolICMP, dst: fakeDest, id: 0x1234, seq: 7}, 7},
+		{"time exceeded of another destination", icmpMessage{timeExceeded: true, proto: protocolICMP, dst: other, id: 0x1234, seq: 7}, -1},
+		{"port unreachable", icmpMessage{unreachable: true, proto: protocolUDP, dst: fakeDest, srcPort: 40000, dstPort: 33436}, 8},
+		{"port unreachable of another destination", icmpMessage{unreachable: true, proto: protocolUDP, dst: other, srcPort: 40000, dstPort: 33436}, -1},
+		{"port unreachable of another port", icmpMessage{unreachable: true, proto: protocolUDP, dst: fakeDest, srcPort: 40000, dstPort: 53}, -1},
+		{"TCP probe from the same port", icmpMessage{timeExceeded: true, proto: protocolTCP, dst: fakeDest, srcPort: 40000, dstPort: 33436}, -1},
+	}
+	match := func(m icmpMessage) int {
+		key, ok := c.probeKey(&m)
+		if !ok {
+			return -1
+		}
+		seq, ok := c.lookup(key)
+		if !ok {
+			return -1
+		}
+		return seq
+	}
+	for _, test := range tests {
+		if seq := match(test.m); seq != test.seq {
+			t.Errorf("%s: got probe %d, want %d", test.name, seq, test.seq)
+		}
+	}
+
+	// the port may be taken by someone else once the cycle's sockets are closed
+	c.endCycle()
+	if seq := match(tests[4].m); seq != -1 {
+		t.Errorf("port unreachable after the end of the cycle: got probe %d", seq)
+	}
+	if seq := match(tests[0].m); seq != 7 {
+		t.Errorf("echo reply after the end of the cycle: got probe %d, want 7", seq)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "fmt"
+
+// newProbeConn is only implemented for linux, other systems use the mtr engine
+func newProbeConn(opts nativeOptions) (probeConn, error) {
+	return nil, fmt.Errorf("the native engine is only supported on linux")
+}
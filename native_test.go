@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeConn answers probes as told by answer, which returns the address that
+// replies to the probe with the TTL in the cycle and whether it is the
+// destination, nil for no reply
+type fakeConn struct {
+	maxHops int
+	answer  func(cycle int, ttl int) (net.IP, bool)
+	// duplicate sends every reply twice
+	duplicate bool
+	// late holds back the replies of a cycle until the next cycle
+	late bool
+
+	replies []*probeReply
+	held    []*probeReply
+	sent    []int
+	cycles  int
+	closed  bool
+}
+
+func (c *fakeConn) send(seq int, ttl int) error {
+	c.sent = append(c.sent, seq)
+	from, final := c.answer(seq/c.maxHops, ttl)
+	if from == nil {
+		return nil
+	}
+	// a round trip time of ttl milliseconds
+	reply := &probeReply{seq: seq, from: from, final: final, received: time.Now().Add(time.Duration(ttl) * time.Millisecond)}
+	if c.late {
+		c.held = append(c.held, reply)
+		return nil
+	}
+	c.replies = append(c.replies, reply)
+	if c.duplicate {
+		c.replies = append(c.replies, reply)
+	}
+	return nil
+}
+
+func (c *fakeConn) receive(deadline time.Time) (*probeReply, error) {
+	if len(c.replies) == 0 {
+		return nil, errReceiveTimeout
+	}
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	return r, nil
+}
+
+func (c *fakeConn) endCycle() {
+	c.cycles++
+	c.replies = append(c.replies, c.held...)
+	c.held = nil
+}
+
+func (c *fakeConn) close() error {
+	c.closed = true
+	return nil
+}
+
+var (
+	fakeRouter1 = net.ParseIP("192.168.1.1")
+	fakeRouter2 = net.ParseIP("10.0.0.1")
+	fakeDest    = net.ParseIP("93.184.216.34")
+)
+
+func fakeOptions(cycles int) nativeOptions {
+	return nativeOptions{
+		destination: fakeDest,
+		protocol:    "icmp",
+		cycles:      cycles,
+		interval:    time.Millisecond,
+		maxHops:     nativeMaxHops,
+	}
+}
+
+// threeHops answers with two routers and the destination at TTL 3
+func threeHops(cycle int, ttl int) (net.IP, bool) {
+	switch ttl {
+	case 1:
+		return fakeRouter1, false
+	case 2:
+		return fakeRouter2, false
+	}
+	return fakeDest, true
+}
+
+func TestNativeTraceReachedEarly(t *testing.T) {
+	conn := &fakeConn{maxHops: nativeMaxHops, answer: threeHops}
+	hops, err := nativeTrace(context.Background(), conn, fakeOptions(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 3 {
+		t.Fatalf("got %d hops, want 3", len(hops))
+	}
+	for i, want := range []net.IP{fakeRouter1, fakeRouter2, fakeDest} {
+		if !hops[i].IP.Equal(want) {
+			t.Errorf("hop %d: got %s, want %s", i, hops[i].IP, want)
+		}
+		if hops[i].Sent != 2 || hops[i].Received != 2 {
+			t.Errorf("hop %d: got %d of %d received, want 2 of 2", i, hops[i].Received, hops[i].Sent)
+		}
+		if min := (i + 1) * 1000; hops[i].Best < min {
+			t.Errorf("hop %d: best %dµs, want at least %dµs", i, hops[i].Best, min)
+		}
+	}
+	// the second cycle only probes up to the destination
+	if want := nativeMaxHops + 3; len(conn.sent) != want {
+		t.Errorf("sent %d probes, want %d", len(conn.sent), want)
+	}
+	if conn.cycles != 2 {
+		t.Errorf("ended %d cycles, want 2", conn.cycles)
+	}
+}
+
+func TestNativeTraceSilentHops(t *testing.T) {
+	conn := &fakeConn{maxHops: nativeMaxHops, answer: func(cycle int, ttl int) (net.IP, bool) {
+		if ttl == 2 {
+			return nil, false
+		}
+		return threeHops(cycle, ttl)
+	}}
+	hops, err := nativeTrace(context.Background(), conn, fakeOptions(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 3 {
+		t.Fatalf("got %d hops, want 3", len(hops))
+	}
+	if hops[1].IP != nil || hops[1].Received != 0 || hops[1].LostPercent != 1 || len(hops[1].Responders) != 0 {
+		t.Errorf("silent hop: got %s with %d received and loss %v", hops[1].IP, hops[1].Received, hops[1].LostPercent)
+	}
+	if !hops[2].IP.Equal(fakeDest) {
+		t.Errorf("last hop: got %s, want %s", hops[2].IP, fakeDest)
+	}
+}
+
+func TestNativeTraceTrailingSilence(t *testing.T) {
+	// a firewall after the second router drops everything
+	conn := &fakeConn{maxHops: nativeMaxHops, answer: func(cycle int, ttl int) (net.IP, bool) {
+		if ttl > 2 {
+			return nil, false
+		}
+		return threeHops(cycle, ttl)
+	}}
+	hops, err := nativeTrace(context.Background(), conn, fakeOptions(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 {
+		t.Errorf("got %d hops, want 2", len(hops))
+	}
+}
+
+func TestNativeTraceNoReply(t *testing.T) {
+	conn := &fakeConn{maxHops: nativeMaxHops, answer: func(int, int) (net.IP, bool) { return nil, false }}
+	hops, err := nativeTrace(context.Background(), conn, fakeOptions(2))
+	if err == nil {
+		t.Fatalf("got %d hops, want an error", len(hops))
+	}
+	if reason := failureReason(err); reason != reasonNoReply {
+		t.Errorf("got reason %s, want %s", reason, reasonNoReply)
+	}
+}
+
+func TestNativeTraceDuplicateReplies(t *testing.T) {
+	conn := &fakeConn{maxHops: nativeMaxHops, answer: threeHops, duplicate: true}
+	hops, err := nativeTrace(context.Background(), conn, fakeOptions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, hop := range hops {
+		if hop.Received != 3 || hop.Dropped != 0 {
+			t.Errorf("hop %d: got %d received and %d dropped, want 3 and 0", i, hop.Received, hop.Dropped)
+		}
+	}
+}
+
+func TestNativeTraceLateReplies(t *testing.T) {
+	// replies arrive in the next cycle, those of the last cycle never
+	conn := &fakeConn{maxHops: nativeMaxHops, answer: threeHops, late: true}
+	hops, err := nativeTrace(context.Background(), conn, fakeOptions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 3 {
+		t.Fatalf("got %d hops, want 3", len(hops))
+	}
+	for i, hop := range hops {
+		if hop.Sent != 3 || hop.Received != 2 {
+			t.Errorf("hop %d: got %d of %d received, want 2 of 3", i, hop.Received, hop.Sent)
+		}
+	}
+}
+
+func TestNativeTraceCycles(t *testing.T) {
+	// the second router alternates between two addresses
+	other := net.ParseIP("10.0.0.2")
+	conn := &fakeConn{maxHops: nativeMaxHops, answer: func(cycle int, ttl int) (net.IP, bool) {
+		if ttl == 2 && cycle%2 == 1 {
+			return other, false
+		}
+		return threeHops(cycle, ttl)
+	}}
+	hops, err := nativeTrace(context.Background(), conn, fakeOptions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.cycles != 3 {
+		t.Errorf("ended %d cycles, want 3", conn.cycles)
+	}
+	hop := hops[1]
+	if len(hop.Responders) != 2 {
+		t.Fatalf("got %d responders, want 2", len(hop.Responders))
+	}
+	if !hop.IP.Equal(fakeRouter2) {
+		t.Errorf("got primary %s, want %s which answered most probes", hop.IP, fakeRouter2)
+	}
+	if hop.responder(fakeRouter2).Received != 2 || hop.responder(other).Received != 1 {
+		t.Errorf("got %d and %d replies per responder, want 2 and 1",
+			hop.responder(fakeRouter2).Received, hop.responder(other).Received)
+	}
+	if got := hop.responderSet(); got != "10.0.0.1,10.0.0.2" {
+		t.Errorf("got responder set %s", got)
+	}
+}
+
+// ICMP messages without IP header as read from a raw socket
+const (
+	// echo reply with identifier 0x1234 and sequence number 5
+	capturedEchoReply = "0000edc612340005" +
+		"00000000000000000000000000000000000000000000000000000000"
+	// time exceeded for an echo request with identifier 0x1234 and sequence
+	// number 7 from 192.168.1.10 to 93.184.216.34
+	capturedTimeExceeded = "0b00f4ff00000000" +
+		"450000383c1e40000101461ac0a8010a5db8d822" +
+		"0800e5c412340007"
+	// port unreachable for a UDP datagram from port 40000 to 33436
+	capturedPortUnreachable = "0303ddff00000000" +
+		"450000343c1e40000311440ec0a8010a5db8d822" +
+		"9c40829c00200000"
+	// ICMPv6 time exceeded for an echo request with identifier 0x1234 and
+	// sequence number 9 from 2001:db8::10 to 2001:db8::1
+	capturedTimeExceededV6 = "0300beef00000000" +
+		"6000000000083a0220010db800000000000000000000001020010db8000000000000000000000001" +
+		"8000000012340009"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseICMP(t *testing.T) {
+	tests := []struct {
+		name   string
+		ipv6   bool
+		packet string
+		want   icmpMessage
+	}{
+		{"echo reply", false, capturedEchoReply, icmpMessage{echoReply: true, id: 0x1234, seq: 5}},
+		{"time exceeded", false, capturedTimeExceeded, icmpMessage{timeExceeded: true, proto: protocolICMP, dst: fakeDest.To4(), id: 0x1234, seq: 7}},
+		{"port unreachable", false, capturedPortUnreachable, icmpMessage{unreachable: true, proto: protocolUDP, dst: fakeDest.To4(), srcPort: 40000, dstPort: 33436}},
+		{"time exceeded v6", true, capturedTimeExceededV6, icmpMessage{timeExceeded: true, proto: protocolICMPv6, dst: net.ParseIP("2001:db8::1"), id: 0x1234, seq: 9}},
+	}
+	for _, test := range tests {
+		m, err := parseICMP(test.ipv6, decodeHex(t, test.packet))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*m, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *m, test.want)
+		}
+	}
+}
+
+func TestParseICMPErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		ipv6   bool
+		packet string
+	}{
+		{"too short", false, "0000edc6"},
+		{"echo request", false, "0800e5c412340007"},
+		{"truncated inner header", false, capturedTimeExceeded[:40]},
+		{"truncated inner payload", false, capturedTimeExceeded[:len(capturedTimeExceeded)-4]},
+		{"truncated inner header v6", true, capturedTimeExceededV6[:80]},
+		{"v4 type as v6", true, capturedTimeExceeded},
+	}
+	for _, test := range tests {
+		if m, err := parseICMP(test.ipv6, decodeHex(t, test.packet)); err == nil {
+			t.Errorf("%s: got %+v, want an error", test.name, *m)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// the example of RFC 1071 section 3
+	if got := checksum([]byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}); got != 0x220d {
+		t.Errorf("got %#04x, want 0x220d", got)
+	}
+	// odd length
+	if got := checksum([]byte{0x00, 0x01, 0xf2}); got != 0x0dfe {
+		t.Errorf("got %#04x, want 0x0dfe", got)
+	}
+	// the checksum over a message including its checksum is 0
+	for _, packet := range []string{capturedEchoReply, capturedTimeExceeded, capturedPortUnreachable} {
+		if got := checksum(decodeHex(t, packet)); got != 0 {
+			t.Errorf("checksum of %s...: got %#04x, want 0", packet[:16], got)
+		}
+	}
+	// and over the embedded IPv4 header
+	if got := checksum(decodeHex(t, capturedTimeExceeded[16:56])); got != 0 {
+		t.Errorf("checksum of embedded header: got %#04x, want 0", got)
+	}
+}
+
+func TestICMPEcho(t *testing.T) {
+	b := icmpEcho(false, 0x1234, 7, 36)
+	if len(b) != 36 {
+		t.Fatalf("got %d bytes, want 36", len(b))
+	}
+	if checksum(b) != 0 {
+		t.Errorf("invalid checksum")
+	}
+	// answer it the way a host does and parse the reply
+	b[0] = icmpv4EchoReply
+	b[2], b[3] = 0, 0
+	c := checksum(b)
+	b[2], b[3] = byte(c>>8), byte(c)
+	m, err := parseICMP(false, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.echoReply || m.id != 0x1234 || m.seq != 7 {
+		t.Errorf("got %+v", *m)
+	}
+}
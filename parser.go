@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	mtr "github.com/Shinzu/go-mtr"
+)
+
+// parser converts the output of mtr in one of its output formats into hops
+type parser struct {
+	// flag makes mtr use the output format
+	flag string
+	// args are additional arguments the format needs
+	args  []string
+	parse func(output []byte, cycles int) ([]*Hop, error)
+}
+
+// parsers are the output formats selectable by the format setting. The
+// report formats need --show-ips to print the address next to the host name.
+var parsers = map[string]parser{
+	"raw":  {flag: "--raw", parse: parseRaw},
+	"json": {flag: "--json", args: []string{"--show-ips"}, parse: parseJSON},
+	"xml":  {flag: "--xml", args: []string{"--show-ips"}, parse: parseXML},
+	"csv":  {flag: "--csv", args: []string{"--show-ips"}, parse: parseCSV},
+}
+
+// parseRaw parses the output of mtr --raw into the hops of the trace.
+// Unlike go-mtr it returns an error instead of panicking on unexpected lines.
+func parseRaw(output []byte, cycles int) ([]*Hop, error) {
+	// h (host): host #, ip address
+	// d (dns): host #, resolved dns name
+	// p (packet): host #, microseconds
+	// m (mpls): host #, label, traffic class, bottom of stack, ttl
+	var hosts []*Hop
+	current := make(map[int]*Responder)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed mtr output line %q", line)
+		}
+		hostnum, err := strconv.Atoi(fields[1])
+		if err != nil || hostnum < 0 {
+			return nil, fmt.Errorf("malformed host number in mtr output line %q", line)
+		}
+
+		switch fields[0] {
+		case "h":
+			// mtr prints a host line when an address first answers for the
+			// hop. Packet lines don't tell which address answered, so the
+			// responders of the hop are known but not their replies.
+			for len(hosts) < hostnum+1 {
+				hosts = append(hosts, newHop(len(hosts)))
+			}
+			current[hostnum] = hosts[hostnum].responder(net.ParseIP(fields[2]))
+			// the label stack follows the host line, top label first
+			current[hostnum].MPLS = nil
+		case "d":
+			if r, ok := current[hostnum]; ok {
+				r.Name = fields[2]
+			}
+		case "m":
+			label, err := strconv.Atoi(fields[2])
+			if err != nil || label < 0 || label > maxMPLSLabel {
+				return nil, fmt.Errorf("malformed MPLS label in mtr output line %q", line)
+			}
+			if r, ok := current[hostnum]; ok {
+				r.MPLS = append(r.MPLS, label)
+			}
+		case "p":
+			if hostnum >= len(hosts) {
+				return nil, fmt.Errorf("packet for unknown host in mtr output line %q", line)
+			}
+			n, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("malformed packet time in mtr output line %q", line)
+			}
+			hosts[hostnum].PacketMicrosecs = append(hosts[hostnum].PacketMicrosecs, n)
+		}
+	}
+
+	for _, host := range hosts {
+		host.summarize(cycles)
+	}
+	return hosts, nil
+}
+
+// hostStats fills in the statistics of a hop from its packet times, the
+// same way go-mtr does for the output of mtr. Unlike go-mtr the standard
+// deviation is divided by the number of samples instead of the mean.
+func hostStats(host *mtr.Host, sent int) {
+	host.Sent = sent
+	host.Received = len(host.PacketMicrosecs)
+	// duplicate and late replies can exceed the probes sent, which is no
+	// negative loss
+	host.Dropped = host.Sent - host.Received
+	if host.Dropped < 0 {
+		host.Dropped = 0
+	}
+	host.LostPercent = float64(host.Dropped) / float64(host.Sent)
+	if host.Received == 0 {
+		return
+	}
+	totalPacketTime := 0
+	best := 1<<31 - 1
+	worst := 0
+	jitters := make([]int, host.Received)
+	worstJitter := 0
+	for i, packet := range host.PacketMicrosecs {
+		if i > 0 {
+			newJitter := packet - host.PacketMicrosecs[i-1]
+			if newJitter < 0 {
+				newJitter = -newJitter
+			}
+			if newJitter > worstJitter {
+				worstJitter = newJitter
+			}
+			jitters[i] = newJitter
+			host.InterarrivalJitter += newJitter - ((host.InterarrivalJitter + 8) >> 4) // rfc3550 A.8
+		}
+		totalPacketTime += packet
+		if packet > worst {
+			worst = packet
+		}
+		if packet < best {
+			best = packet
+		}
+	}
+	host.Mean = float64(totalPacketTime) / float64(host.Received)
+	host.WorstJitter = worstJitter
+	host.Best = best
+	host.Worst = worst
+	sqrDiff := float64(0)
+	jitterSum := 0
+	for i, packet := range host.PacketMicrosecs {
+		diff := float64(packet) - host.Mean
+		sqrDiff += diff * diff
+		jitterSum += jitters[i]
+	}
+	host.MeanJitter = float64(jitterSum) / float64(host.Received)
+	host.StandardDev = math.Sqrt(sqrDiff / float64(host.Received))
+}
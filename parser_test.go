@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRaw(t *testing.T) {
+	hops, err := parseRaw(readTestdata(t, "raw.txt"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mtr prints nothing for hops that did not answer
+	checkHops(t, "raw.txt", hops, []wantHop{
+		{"192.168.1.1", "router.lan", "", 3, 3, 0, 500, 488, 512},
+		{"10.0.0.1", "", "", 3, 3, 0, 8474.33, 8123, 9000},
+		{sent: 3, loss: 1},
+		{"93.184.216.34", "example.com", "", 3, 3, 0, 20150, 20050, 20300},
+	})
+	if got := hops[1].responderSet(); got != "10.0.0.1,10.0.0.2" {
+		t.Errorf("got responder set %s of the second hop", got)
+	}
+	// packet lines don't tell which responder answered
+	for _, hop := range hops {
+		if hop.attributed() {
+			t.Errorf("hop %d: got replies attributed to responders", hop.Hop)
+		}
+	}
+}
+
+func TestParseRawMPLS(t *testing.T) {
+	output := "h 0 10.0.0.1\nm 0 16004 0 0 254\nm 0 24001 0 1 254\np 0 1000 0\n" +
+		"h 1 10.0.0.2\np 1 2000 1\n"
+	hops, err := parseRaw([]byte(output), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 {
+		t.Fatalf("got %d hops, want 2", len(hops))
+	}
+	if !reflect.DeepEqual(hops[0].MPLS, []int{16004, 24001}) || hops[1].MPLS != nil {
+		t.Errorf("got label stacks %v and %v, want [16004 24001] and none", hops[0].MPLS, hops[1].MPLS)
+	}
+}
+
+func TestParseRawErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"short line", "h 0\n"},
+		{"invalid host number", "h x 10.0.0.1\n"},
+		{"negative host number", "h -1 10.0.0.1\n"},
+		{"packet of unknown host", "h 0 10.0.0.1\np 1 1000 0\n"},
+		{"invalid packet time", "h 0 10.0.0.1\np 0 fast 0\n"},
+		{"invalid MPLS label", "h 0 10.0.0.1\nm 0 1048576 0 1 254\n"},
+	}
+	for _, test := range tests {
+		if hops, err := parseRaw([]byte(test.output), 1); err == nil {
+			t.Errorf("%s: got %d hops, want an error", test.name, len(hops))
+		}
+	}
+}
+
+func TestParseRawDuplicates(t *testing.T) {
+	// more replies than probes, as when late replies of earlier cycles arrive
+	hops, err := parseRaw([]byte("h 0 10.0.0.1\np 0 1000 0\np 0 1100 0\n"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hops[0].Dropped != 0 || hops[0].LostPercent != 0 {
+		t.Errorf("got %d dropped and loss %v, want 0", hops[0].Dropped, hops[0].LostPercent)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// defaultProbeTimeout is used when Prometheus does not send its scrape timeout
+	defaultProbeTimeout = 10 * time.Second
+
+	// probeTimeoutOffset is subtracted from the scrape timeout to leave time
+	// for sending the response
+	probeTimeoutOffset = 500 * time.Millisecond
+)
+
+// probeTimeout returns the time a probe may take, based on the scrape timeout
+// Prometheus sends along with the request
+func probeTimeout(r *http.Request) (time.Duration, error) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return defaultProbeTimeout, nil
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse timeout from Prometheus header: %s", err)
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > probeTimeoutOffset {
+		timeout -= probeTimeoutOffset
+	}
+	return timeout, nil
+}
+
+// probeHandler runs a single trace against the target given in the query string
+// and returns the metrics of just this trace, like the blackbox_exporter does
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(target, "-") {
+		// mtr would take it for an option
+		http.Error(w, fmt.Sprintf("Invalid target %q", target), http.StatusBadRequest)
+		return
+	}
+	module := params.Get("module")
+	if _, ok := lookupModule(module); module != "" && !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", module), http.StatusBadRequest)
+		return
+	}
+
+	timeout, err := probeTimeout(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		hopID = "hop_id"
+		hopIP = "hop_ip"
+	)
+	reg := &registry{}
+	success := reg.newGaugeVec("probe_success", "whether the trace was successful")
+	duration := reg.newGaugeVec("probe_duration_seconds", "how long the trace took to complete in seconds")
+	hops := reg.newGaugeVec("probe_hops", "number of hops of the trace")
+	reached := reg.newGaugeVec("probe_destination_reached", "whether the target address answered")
+	lossOrigin := reg.newGaugeVec("probe_loss_origin_hop", "first hop of the end-to-end loss, -1 without loss")
+	sent := reg.newGaugeVec("hop_sent", "packets sent", hopID, hopIP)
+	received := reg.newGaugeVec("hop_received", "packets received", hopID, hopIP)
+	dropped := reg.newGaugeVec("hop_dropped", "packets dropped", hopID, hopIP)
+	loss := reg.newGaugeVec("hop_loss_ratio", "ratio of packets lost", hopID, hopIP)
+	effectiveLoss := reg.newGaugeVec("hop_effective_loss_ratio", "ratio of packets lost at the hop and all hops after it", hopID, hopIP)
+	latency := reg.newGaugeVec("hop_latency_seconds", "mean round trip time in seconds", hopID, hopIP)
+
+	host := Host{Name: target, Alias: target, ModuleName: module}
+	if t := host.timeout(); t > 0 && t < timeout {
+		timeout = t
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	start := time.Now()
+	result, err := probe(ctx, defaultProbers, host)
+	duration.WithLabelValues().Set(time.Since(start).Seconds())
+	if err != nil {
+		log.Errorf("probe of %v failed: %v", target, err)
+		success.WithLabelValues().Set(0)
+	} else {
+		success.WithLabelValues().Set(1)
+		hops.WithLabelValues().Set(float64(len(result.Hops)))
+		if result.Reached {
+			reached.WithLabelValues().Set(1)
+		} else {
+			reached.WithLabelValues().Set(0)
+		}
+		hopLoss := result.effectiveLoss()
+		lossOrigin.WithLabelValues().Set(float64(result.lossOrigin(hopLoss)))
+		for i, hop := range result.Hops {
+			labels := []string{strconv.Itoa(hop.Hop), hop.IP.String()}
+			sent.WithLabelValues(labels...).Set(float64(hop.Sent))
+			received.WithLabelValues(labels...).Set(float64(hop.Received))
+			dropped.WithLabelValues(labels...).Set(float64(hop.Dropped))
+			loss.WithLabelValues(labels...).Set(hop.LostPercent)
+			effectiveLoss.WithLabelValues(labels...).Set(hopLoss[i])
+			latency.WithLabelValues(labels...).Set(hop.Mean / 1e6)
+		}
+	}
+
+	reg.ServeHTTP(w, r)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mtr "github.com/Shinzu/go-mtr"
+)
+
+// Prober traces a host. Implementations must give up once ctx is done.
+type Prober interface {
+	Probe(ctx context.Context, host Host) (*TraceResult, error)
+}
+
+// TraceResult is the outcome of a single successful trace
+type TraceResult struct {
+	Hops     []*Hop
+	Start    time.Time
+	Duration time.Duration
+	// Addresses are the addresses traced, the one the target resolved to
+	// before the trace. Destination is the one that answered at the last hop
+	// or, if none did, the lowest of them. Both are empty if the prober
+	// doesn't know the address traced.
+	Addresses   []net.IP
+	Destination net.IP
+	// Reached is set if the destination answered
+	Reached bool
+}
+
+// Hop is a single hop of a trace along with its packet statistics. IP and
+// Name are those of the responder that answered most of the probes or, if
+// the replies are not attributed to responders, of the first responder.
+type Hop struct {
+	mtr.Host
+	// ASN is the autonomous system of the hop as reported by mtr or looked
+	// up in the ASN database, if any. ASOrg is its organisation.
+	ASN   string
+	ASOrg string
+	// Geo is the location of the hop from the GeoIP database, if known.
+	// RTTFloor is the round trip time in microseconds light in fiber needs
+	// from the previous located hop, Inflation how many times that the hop
+	// took. Both are 0 if unknown.
+	Geo       *geoLocation
+	RTTFloor  float64
+	Inflation float64
+	// MPLS is the label stack of the primary responder, see Responder
+	MPLS []int
+	// Responders are all addresses that answered for the hop, there is more
+	// than one on load balanced (ECMP) paths
+	Responders []*Responder
+}
+
+// Responder is an address that answered probes of a hop. PacketMicrosecs
+// and Received are empty if the engine can't tell which address sent a
+// reply, see Hop.attributed.
+type Responder struct {
+	IP              net.IP
+	Name            string
+	PacketMicrosecs []int
+	Received        int
+	// Mean is the mean latency of the replies of this responder in microseconds
+	Mean float64
+	// ASN and ASOrg are the autonomous system of the address, Geo its
+	// location, if known
+	ASN   string
+	ASOrg string
+	Geo   *geoLocation
+	// MPLS is the label stack the address reported in ICMP extensions,
+	// top label first, as printed by mtr --mpls
+	MPLS []int
+}
+
+// maxMPLSLabel is the highest MPLS label, labels have 20 bits
+const maxMPLSLabel = 1<<20 - 1
+
+// defaultProbers are the probers selectable by the engine setting
+var defaultProbers = map[string]Prober{
+	"mtr":    mtrProber{},
+	"native": nativeProber{},
+}
+
+// newHop returns an empty hop with the given 0-based hop number
+func newHop(hop int) *Hop {
+	return &Hop{Host: mtr.Host{Hop: hop}}
+}
+
+// responder returns the responder of the hop with the address, it is added
+// if the address did not answer before
+func (h *Hop) responder(ip net.IP) *Responder {
+	for _, r := range h.Responders {
+		if r.IP.Equal(ip) {
+			return r
+		}
+	}
+	r := &Responder{IP: ip}
+	h.Responders = append(h.Responders, r)
+	return r
+}
+
+// summarize fills in the statistics of the hop and its responders from the
+// packet times after sent probes
+func (h *Hop) summarize(sent int) {
+	hostStats(&h.Host, sent)
+
+	var primary *Responder
+	for _, r := range h.Responders {
+		r.Received = len(r.PacketMicrosecs)
+		if r.Received > 0 {
+			total := 0
+			for _, t := range r.PacketMicrosecs {
+				total += t
+			}
+			r.Mean = float64(total) / float64(r.Received)
+		}
+		// on ties, as without attributed replies, the first responder wins
+		if primary == nil || r.Received > primary.Received {
+			primary = r
+		}
+	}
+	if primary != nil {
+		h.IP = primary.IP
+		h.Name = primary.Name
+		h.MPLS = primary.MPLS
+	}
+}
+
+// attributed reports whether the replies of the hop are attributed to its
+// responders. The output of mtr --raw only tells which addresses answered,
+// not which reply came from which of them.
+func (h *Hop) attributed() bool {
+	for _, r := range h.Responders {
+		if r.Received > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// responderSet returns the sorted addresses of all responders of the hop
+func (h *Hop) responderSet() string {
+	ips := make([]string, 0, len(h.Responders))
+	for _, r := range h.Responders {
+		ips = append(ips, r.IP.String())
+	}
+	sort.Strings(ips)
+	return strings.Join(ips, ",")
+}
+
+// mplsStack returns the labels of a stack separated by spaces, top label first
+func mplsStack(labels []int) string {
+	s := make([]string, len(labels))
+	for i, l := range labels {
+		s[i] = strconv.Itoa(l)
+	}
+	return strings.Join(s, " ")
+}
+
+// routeKey identifies the hop within a route: its responder set and, if any
+// responder reported MPLS labels, the label stacks of all responders
+func (h *Hop) routeKey() string {
+	var stacks []string
+	for _, r := range h.Responders {
+		if len(r.MPLS) > 0 {
+			stacks = append(stacks, r.IP.String()+"="+mplsStack(r.MPLS))
+		}
+	}
+	if len(stacks) == 0 {
+		return h.responderSet()
+	}
+	sort.Strings(stacks)
+	return h.responderSet() + "|" + strings.Join(stacks, ",")
+}
+
+// newTraceResult returns the result of a trace started at start
+func newTraceResult(hops []*Hop, start time.Time) *TraceResult {
+	return &TraceResult{
+		Hops:     hops,
+		Start:    start,
+		Duration: time.Since(start),
+	}
+}
+
+// setDestination records the addresses of the target and whether one of them
+// answered at the last hop. Without addresses the destination counts as
+// reached if the last hop answered.
+func (r *TraceResult) setDestination(addrs []net.IP) {
+	r.Addresses = append([]net.IP{}, addrs...)
+	sort.Slice(r.Addresses, func(i, j int) bool {
+		return bytes.Compare(r.Addresses[i].To16(), r.Addresses[j].To16()) < 0
+	})
+	if len(r.Addresses) > 0 {
+		r.Destination = r.Addresses[0]
+	}
+	if len(r.Hops) == 0 {
+		return
+	}
+	last := r.Hops[len(r.Hops)-1]
+	if len(r.Addresses) == 0 {
+		r.Reached = last.Received > 0
+		return
+	}
+	for _, responder := range last.Responders {
+		for _, addr := range r.Addresses {
+			if responder.IP.Equal(addr) {
+				r.Destination = addr
+				r.Reached = true
+				return
+			}
+		}
+	}
+}
+
+// effectiveLoss returns the loss of each hop that persists to all later hops,
+// the lowest loss of the hop and those after it. Routers that rate limit
+// their own replies show loss the hops after them don't have, which is no
+// lost traffic.
+func (r *TraceResult) effectiveLoss() []float64 {
+	loss := make([]float64, len(r.Hops))
+	for i := len(r.Hops) - 1; i >= 0; i-- {
+		loss[i] = r.Hops[i].LostPercent
+		if i < len(r.Hops)-1 && loss[i+1] < loss[i] {
+			loss[i] = loss[i+1]
+		}
+	}
+	return loss
+}
+
+// lossOrigin returns the first hop with effective loss, where the end-to-end
+// loss begins. If the destination wasn't reached although no hop lost
+// packets, the loss begins after the last hop. It returns -1 without loss.
+func (r *TraceResult) lossOrigin(loss []float64) int {
+	for i, l := range loss {
+		if l > 0 {
+			return i
+		}
+	}
+	if !r.Reached {
+		return len(loss)
+	}
+	return -1
+}
+
+// resolveTarget looks up the address to trace, the first address of the
+// target of the address family. It is resolved once before the trace, so the
+// address traced is the one the destination is compared with.
+func resolveTarget(ctx context.Context, name string, addressFamily string) (net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("lookup of %s did not finish: %w", name, ctx.Err())
+	}
+	if err != nil {
+		return nil, &TraceError{Reason: reasonDNS, Err: fmt.Errorf("failed to resolve %s: %w", name, err)}
+	}
+	for _, addr := range addrs {
+		ipv4 := addr.IP.To4() != nil
+		if (addressFamily == "ipv4" && !ipv4) || (addressFamily == "ipv6" && ipv4) {
+			continue
+		}
+		return addr.IP, nil
+	}
+	return nil, &TraceError{Reason: reasonDNS, Err: fmt.Errorf("%s has no %s address", name, addressFamily)}
+}
+
+// probe traces the host with the prober of the host's engine. A trace
+// without hops fails, nothing can be reported for it.
+func probe(ctx context.Context, probers map[string]Prober, host Host) (*TraceResult, error) {
+	prober, ok := probers[host.engine()]
+	if !ok {
+		return nil, fmt.Errorf("no prober for engine %q", host.engine())
+	}
+	result, err := prober.Probe(ctx, host)
+	if err == nil && (result == nil || len(result.Hops) == 0) {
+		return nil, errNoReply
+	}
+	return result, err
+}
+
+// mtrProber runs the mtr binary
+type mtrProber struct{}
+
+func (mtrProber) Probe(ctx context.Context, host Host) (*TraceResult, error) {
+	start := time.Now()
+	// mtr doesn't print the address it traced, so it traces the address
+	// resolved here instead of resolving the target itself
+	dst, err := resolveTarget(ctx, host.Name, host.settings().AddressFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	cycles := host.cycles()
+	p := parsers[host.format()]
+	args := append([]string{p.flag, "-c", strconv.Itoa(cycles)}, p.args...)
+	args = append(args, host.arguments()...)
+	// the target goes last, after -- so it is never taken for an option
+	args = append(args, "--", dst.String())
+
+	cmd := exec.CommandContext(ctx, "mtr", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// kill mtr along with its helpers when the context is done
+	setProcessGroup(cmd)
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("mtr did not finish: %w", ctx.Err())
+	}
+	if err != nil {
+		return nil, newExitError(err, stderr.String())
+	}
+
+	hops, err := p.parse(output, cycles)
+	if err != nil {
+		return nil, &TraceError{Reason: reasonParse, Err: fmt.Errorf("failed to parse mtr %s output: %w", host.format(), err)}
+	}
+	result := newTraceResult(hops, start)
+	result.setDestination([]net.IP{dst})
+	return result, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResolveTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		family string
+		want   string
+	}{
+		{"192.0.2.1", "", "192.0.2.1"},
+		{"192.0.2.1", "ipv4", "192.0.2.1"},
+		{"192.0.2.1", "ipv6", ""},
+		{"2001:db8::1", "ipv6", "2001:db8::1"},
+		{"2001:db8::1", "ipv4", ""},
+	}
+	for _, test := range tests {
+		ip, err := resolveTarget(context.Background(), test.name, test.family)
+		if test.want == "" {
+			if reason := failureReason(err); reason != reasonDNS {
+				t.Errorf("%s %s: got %s and reason %s, want reason %s", test.name, test.family, ip, reason, reasonDNS)
+			}
+			continue
+		}
+		if err != nil || !ip.Equal(net.ParseIP(test.want)) {
+			t.Errorf("%s %s: got %s and %v, want %s", test.name, test.family, ip, err, test.want)
+		}
+	}
+}
+
+func TestResolveTargetTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if _, err := resolveTarget(ctx, "example.com", ""); failureReason(err) != reasonTimeout {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
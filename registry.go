@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricFamily is a collector whose metrics all share one name and type
+type metricFamily struct {
+	name       string
+	help       string
+	metricType dto.MetricType
+	collector  prometheus.Collector
+}
+
+// registry is a minimal private registry. The vendored client library only
+// knows the global default registry, but the /probe handler needs to return
+// metrics for a single trace without registering them globally.
+type registry struct {
+	families []metricFamily
+}
+
+func (r *registry) newGaugeVec(name string, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)
+	r.families = append(r.families, metricFamily{
+		name:       prometheus.BuildFQName(Namespace, "", name),
+		help:       help,
+		metricType: dto.MetricType_GAUGE,
+		collector:  g,
+	})
+	return g
+}
+
+// gather collects all metrics, families without any metric are skipped
+func (r *registry) gather() ([]*dto.MetricFamily, error) {
+	var result []*dto.MetricFamily
+	for _, family := range r.families {
+		ch := make(chan prometheus.Metric)
+		go func(c prometheus.Collector) {
+			c.Collect(ch)
+			close(ch)
+		}(family.collector)
+
+		mf := &dto.MetricFamily{
+			Name: proto.String(family.name),
+			Help: proto.String(family.help),
+			Type: family.metricType.Enum(),
+		}
+		var err error
+		for m := range ch {
+			dm := &dto.Metric{}
+			if e := m.Write(dm); e != nil && err == nil {
+				err = e
+			}
+			mf.Metric = append(mf.Metric, dm)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(mf.Metric) > 0 {
+			result = append(result, mf)
+		}
+	}
+	return result, nil
+}
+
+// ServeHTTP writes all metrics in the format negotiated with the client
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	families, err := r.gather()
+	if err != nil {
+		http.Error(w, "An error has occurred during metrics collection:\n\n"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := expfmt.Negotiate(req.Header)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			http.Error(w, "An error has occurred during metrics encoding:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// reloadMutex serializes reloads triggered by signal and HTTP
+var reloadMutex sync.Mutex
+
+// reload reads the config file and the ASN and GeoIP databases again and applies them.
+// Workers for added hosts are started, those of removed hosts are stopped and
+// their series deleted.
+func (e *Exporter) reload(configFile string) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	c, err := loadConfig(configFile)
+	if err != nil {
+		e.reloadSuccess.Set(0)
+		return err
+	}
+	db, err := loadASNDatabase(c.ASNDatabase)
+	if err != nil {
+		e.reloadSuccess.Set(0)
+		return err
+	}
+	geo, err := loadGeoDatabase(c.GeoIPDatabase)
+	if err != nil {
+		e.reloadSuccess.Set(0)
+		return err
+	}
+	setConfig(c)
+	setASNDatabase(db)
+	setGeoDatabase(geo)
+	e.asnRanges.Set(float64(db.size()))
+	e.geoRanges.Set(float64(geo.size()))
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if cap(e.semaphore) != c.MaxConcurrency {
+		e.semaphore = newSemaphore(c.MaxConcurrency)
+	}
+	if !reflect.DeepEqual(e.latencyBuckets, c.LatencyBuckets) || e.latencyFactor != c.NativeHistogramBucketFactor {
+		// the buckets of a histogram can't be changed, start over with a new one
+		e.series.forget(e.latency)
+		e.latency = newLatencyHistogram(c.LatencyBuckets, c.NativeHistogramBucketFactor)
+		e.latencyBuckets = c.LatencyBuckets
+		e.latencyFactor = c.NativeHistogramBucketFactor
+	}
+	e.updateWorkers(c.Hosts)
+
+	e.reloadSuccess.Set(1)
+	e.reloadSeconds.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// reloadHandler triggers a reload on POST /-/reload
+func reloadHandler(e *Exporter, configFile string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "This endpoint requires a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := e.reload(configFile); err != nil {
+			log.Errorf("Error reloading config: %s", err)
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+		log.Infoln("Reloaded config file", configFile)
+	}
+}
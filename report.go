@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// reportNumber is a number in mtr's report formats. It accepts numbers in
+// strings, as printed by older mtr versions, with padding and percent signs.
+type reportNumber float64
+
+func (n *reportNumber) UnmarshalText(b []byte) error {
+	s := strings.TrimSuffix(strings.TrimSpace(string(b)), "%")
+	if s == "" {
+		*n = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", string(b))
+	}
+	*n = reportNumber(f)
+	return nil
+}
+
+func (n *reportNumber) UnmarshalJSON(b []byte) error {
+	return n.UnmarshalText(bytes.Trim(b, `"`))
+}
+
+// reportHub is a hop as printed by mtr's report formats. Hubs are counted
+// from 1, loss is in percent and times are in milliseconds.
+type reportHub struct {
+	Count reportNumber `json:"count" xml:"COUNT,attr"`
+	Host  string       `json:"host" xml:"HOST,attr"`
+	ASN   string       `json:"ASN" xml:"ASN,attr"`
+	Loss  reportNumber `json:"Loss%" xml:"Loss"`
+	Sent  reportNumber `json:"Snt" xml:"Snt"`
+	Avg   reportNumber `json:"Avg" xml:"Avg"`
+	Best  reportNumber `json:"Best" xml:"Best"`
+	Worst reportNumber `json:"Wrst" xml:"Wrst"`
+	StDev reportNumber `json:"StDev" xml:"StDev"`
+	JAvg  reportNumber `json:"Javg" xml:"Javg"`
+	JMax  reportNumber `json:"Jmax" xml:"Jmax"`
+	JInt  reportNumber `json:"Jint" xml:"Jint"`
+}
+
+// splitHost splits the host of a hub, which is "name (ip)" with --show-ips,
+// the bare address or ??? if no reply was received
+func splitHost(host string) (string, net.IP) {
+	host = strings.TrimSpace(host)
+	if host == "???" {
+		return "", nil
+	}
+	if i := strings.LastIndex(host, " ("); i > 0 && strings.HasSuffix(host, ")") {
+		return host[:i], net.ParseIP(host[i+2 : len(host)-1])
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return "", ip
+	}
+	return host, nil
+}
+
+func (r reportHub) hop() *Hop {
+	ms := func(n reportNumber) float64 { return float64(n) * 1000 }
+
+	hop := newHop(int(r.Count) - 1)
+	hop.Name, hop.IP = splitHost(r.Host)
+	if r.ASN != "" && !strings.Contains(r.ASN, "???") {
+		hop.ASN = strings.TrimSpace(r.ASN)
+	}
+	hop.Sent = int(r.Sent)
+	hop.Received = int(math.Floor(float64(r.Sent)*(1-float64(r.Loss)/100) + 0.5))
+	hop.Dropped = hop.Sent - hop.Received
+	hop.LostPercent = float64(r.Loss) / 100
+	hop.Mean = ms(r.Avg)
+	hop.Best = int(ms(r.Best))
+	hop.Worst = int(ms(r.Worst))
+	hop.StandardDev = ms(r.StDev)
+	hop.MeanJitter = ms(r.JAvg)
+	hop.WorstJitter = int(ms(r.JMax))
+	hop.InterarrivalJitter = int(ms(r.JInt))
+	if hop.IP != nil {
+		// the report formats only print the first responder of a hop
+		hop.Responders = []*Responder{{IP: hop.IP, Name: hop.Name, Received: hop.Received, Mean: hop.Mean, ASN: hop.ASN}}
+	}
+	return hop
+}
+
+// reportHops orders the hubs by their count, filling in missing hops
+func reportHops(hubs []reportHub) ([]*Hop, error) {
+	var hops []*Hop
+	for _, hub := range hubs {
+		if hub.Count < 1 {
+			return nil, fmt.Errorf("invalid hop count %v", float64(hub.Count))
+		}
+		hop := hub.hop()
+		for len(hops) < hop.Hop+1 {
+			hops = append(hops, newHop(len(hops)))
+		}
+		hops[hop.Hop] = hop
+	}
+	return hops, nil
+}
+
+// parseJSON parses the output of mtr --json
+func parseJSON(output []byte, cycles int) ([]*Hop, error) {
+	var report struct {
+		Report struct {
+			Hubs []reportHub `json:"hubs"`
+		} `json:"report"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, err
+	}
+	return reportHops(report.Report.Hubs)
+}
+
+// parseXML parses the output of mtr --xml
+func parseXML(output []byte, cycles int) ([]*Hop, error) {
+	// mtr names the loss element Loss%, which is not a valid XML name
+	output = bytes.Replace(output, []byte("<Loss%>"), []byte("<Loss>"), -1)
+	output = bytes.Replace(output, []byte("</Loss%>"), []byte("</Loss>"), -1)
+
+	var report struct {
+		Hubs []reportHub `xml:"HUB"`
+	}
+	if err := xml.Unmarshal(output, &report); err != nil {
+		return nil, err
+	}
+	return reportHops(report.Hubs)
+}
+
+// parseCSV parses the output of mtr --csv. The columns are looked up by
+// the header line, as the ASN column is only printed with --aslookup.
+func parseCSV(output []byte, cycles int) ([]*Hop, error) {
+	r := csv.NewReader(bytes.NewReader(output))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty output")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"Hop", "Ip", "Loss%", "Snt"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	var hubs []reportHub
+	for _, record := range records[1:] {
+		value := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		number := func(name string, n *reportNumber) {
+			if err == nil {
+				err = n.UnmarshalText([]byte(value(name)))
+			}
+		}
+
+		hub := reportHub{Host: value("Ip"), ASN: value("Asn")}
+		number("Hop", &hub.Count)
+		number("Loss%", &hub.Loss)
+		number("Snt", &hub.Sent)
+		number("Avg", &hub.Avg)
+		number("Best", &hub.Best)
+		number("Wrst", &hub.Worst)
+		number("StDev", &hub.StDev)
+		number("Javg", &hub.JAvg)
+		number("Jmax", &hub.JMax)
+		number("Jint", &hub.JInt)
+		if err != nil {
+			return nil, err
+		}
+		hubs = append(hubs, hub)
+	}
+	return reportHops(hubs)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// wantHop is what a test expects of a parsed hop. Times are in microseconds.
+type wantHop struct {
+	ip       string
+	name     string
+	asn      string
+	sent     int
+	received int
+	loss     float64
+	mean     float64
+	best     int
+	worst    int
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// checkHops compares parsed hops with the expected ones
+func checkHops(t *testing.T, name string, hops []*Hop, want []wantHop) {
+	if len(hops) != len(want) {
+		t.Errorf("%s: got %d hops, want %d", name, len(hops), len(want))
+		return
+	}
+	for i, w := range want {
+		hop := hops[i]
+		if hop.Hop != i {
+			t.Errorf("%s: hop %d: got hop number %d", name, i, hop.Hop)
+		}
+		if w.ip == "" {
+			if hop.IP != nil || len(hop.Responders) != 0 {
+				t.Errorf("%s: hop %d: got %s with %d responders, want no reply", name, i, hop.IP, len(hop.Responders))
+			}
+		} else if !hop.IP.Equal(net.ParseIP(w.ip)) {
+			t.Errorf("%s: hop %d: got address %s, want %s", name, i, hop.IP, w.ip)
+		}
+		if hop.Name != w.name || hop.ASN != w.asn {
+			t.Errorf("%s: hop %d: got name %q and ASN %q, want %q and %q", name, i, hop.Name, hop.ASN, w.name, w.asn)
+		}
+		if hop.Sent != w.sent || hop.Received != w.received || hop.Dropped != w.sent-w.received {
+			t.Errorf("%s: hop %d: got %d sent, %d received and %d dropped, want %d and %d",
+				name, i, hop.Sent, hop.Received, hop.Dropped, w.sent, w.received)
+		}
+		if math.Abs(hop.LostPercent-w.loss) > 1e-9 {
+			t.Errorf("%s: hop %d: got loss %v, want %v", name, i, hop.LostPercent, w.loss)
+		}
+		if math.Abs(hop.Mean-w.mean) > 0.01 || hop.Best != w.best || hop.Worst != w.worst {
+			t.Errorf("%s: hop %d: got mean %v, best %d and worst %d, want %v, %d and %d",
+				name, i, hop.Mean, hop.Best, hop.Worst, w.mean, w.best, w.worst)
+		}
+	}
+}
+
+func TestParseReports(t *testing.T) {
+	// all captured reports are of the same trace of 10 cycles, the second
+	// hop did not answer
+	silent := wantHop{sent: 10, loss: 1}
+	tests := []struct {
+		file  string
+		parse func([]byte, int) ([]*Hop, error)
+		want  []wantHop
+	}{
+		{"report.json", parseJSON, []wantHop{
+			{"192.168.1.1", "router.lan", "", 10, 10, 0, 500, 250, 750},
+			silent,
+			{"93.184.216.34", "example.com", "AS15133", 10, 8, 0.2, 20250, 20000, 21500},
+		}},
+		// older mtr versions print numbers as strings and no names without
+		// --show-ips
+		{"report-strings.json", parseJSON, []wantHop{
+			{"192.168.1.1", "", "", 10, 10, 0, 500, 250, 750},
+			silent,
+			{"93.184.216.34", "", "", 10, 8, 0.2, 20250, 20000, 21500},
+		}},
+		{"report.xml", parseXML, []wantHop{
+			{"192.168.1.1", "router.lan", "", 10, 10, 0, 500, 200, 800},
+			silent,
+			{"93.184.216.34", "example.com", "", 10, 8, 0.2, 20200, 20000, 21500},
+		}},
+		{"report.csv", parseCSV, []wantHop{
+			{"192.168.1.1", "router.lan", "", 10, 10, 0, 500, 250, 750},
+			silent,
+			{"93.184.216.34", "example.com", "", 10, 8, 0.2, 20250, 20000, 21500},
+		}},
+		// with --aslookup the Asn column precedes Loss% and newer versions
+		// add jitter columns
+		{"report-asn.csv", parseCSV, []wantHop{
+			{"192.168.1.1", "router.lan", "", 10, 10, 0, 500, 250, 750},
+			silent,
+			{"93.184.216.34", "example.com", "AS15133", 10, 8, 0.2, 20250, 20000, 21500},
+		}},
+	}
+	for _, test := range tests {
+		hops, err := test.parse(readTestdata(t, test.file), 10)
+		if err != nil {
+			t.Errorf("%s: %s", test.file, err)
+			continue
+		}
+		checkHops(t, test.file, hops, test.want)
+		if len(hops) == 3 && len(hops[0].Responders) != 1 {
+			t.Errorf("%s: got %d responders of the first hop, want 1", test.file, len(hops[0].Responders))
+		}
+	}
+}
+
+func TestParseReportJitter(t *testing.T) {
+	hops, err := parseCSV(readTestdata(t, "report-asn.csv"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hop := hops[2]
+	if math.Abs(hop.MeanJitter-250) > 0.01 || hop.WorstJitter != 1500 || hop.InterarrivalJitter != 2000 {
+		t.Errorf("got jitter %v, %d and %d, want 250, 1500 and 2000", hop.MeanJitter, hop.WorstJitter, hop.InterarrivalJitter)
+	}
+}
+
+func TestParseReportErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  func([]byte, int) ([]*Hop, error)
+		output string
+	}{
+		{"truncated json", parseJSON, `{"report": {"hubs": [{"count": 1`},
+		{"json count 0", parseJSON, `{"report": {"hubs": [{"count": 0, "host": "???"}]}}`},
+		{"json invalid number", parseJSON, `{"report": {"hubs": [{"count": 1, "Loss%": "lots"}]}}`},
+		{"truncated xml", parseXML, `<MTR><HUB COUNT="1" HOST="???"><Loss%>`},
+		{"xml invalid number", parseXML, `<MTR><HUB COUNT="1" HOST="???"><Snt>ten</Snt></HUB></MTR>`},
+		{"empty csv", parseCSV, ``},
+		{"csv without Ip", parseCSV, "Hop,Loss%,Snt\n1,0.00,10\n"},
+		{"csv invalid number", parseCSV, "Hop,Ip,Loss%,Snt\n1,???,none,10\n"},
+	}
+	for _, test := range tests {
+		if hops, err := test.parse([]byte(test.output), 10); err == nil {
+			t.Errorf("%s: got %d hops, want an error", test.name, len(hops))
+		}
+	}
+}
+
+func TestSplitHost(t *testing.T) {
+	tests := []struct {
+		host string
+		name string
+		ip   string
+	}{
+		{"router.lan (192.168.1.1)", "router.lan", "192.168.1.1"},
+		{"  router.lan (2001:db8::1) ", "router.lan", "2001:db8::1"},
+		{"192.168.1.1", "", "192.168.1.1"},
+		{"2001:db8::1", "", "2001:db8::1"},
+		{"router.lan", "router.lan", ""},
+		{"???", "", ""},
+	}
+	for _, test := range tests {
+		name, ip := splitHost(test.host)
+		if name != test.name || (test.ip == "" && ip != nil) || (test.ip != "" && !ip.Equal(net.ParseIP(test.ip))) {
+			t.Errorf("%q: got %q and %s, want %q and %s", test.host, name, ip, test.name, test.ip)
+		}
+	}
+}
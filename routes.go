@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultRouteHistory is the number of paths kept per host if route_history is not set
+const defaultRouteHistory = 10
+
+// routeHop is a hop of a path along with all addresses that answered for it
+// and the MPLS label stacks they reported, if any
+type routeHop struct {
+	Hop       int               `json:"hop_id"`
+	Addresses []string          `json:"addresses"`
+	MPLS      map[string]string `json:"mpls,omitempty"`
+}
+
+// routePath is a period during which a host was traced along the same path
+type routePath struct {
+	Fingerprint string     `json:"fingerprint"`
+	FirstSeen   time.Time  `json:"first_seen"`
+	LastSeen    time.Time  `json:"last_seen"`
+	Traces      int        `json:"traces"`
+	Hops        []routeHop `json:"hops"`
+}
+
+// routeFingerprint hashes the responder sets of all hops of a route along
+// with their MPLS label stacks, so a changed LSP is a new path even if the
+// addresses stay the same. Paths without labels hash as before labels were
+// taken into account.
+func routeFingerprint(hops []*Hop) string {
+	keys := make([]string, len(hops))
+	for i, hop := range hops {
+		keys[i] = hop.routeKey()
+	}
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func newRoutePath(fingerprint string, hops []*Hop, seen time.Time) *routePath {
+	p := &routePath{
+		Fingerprint: fingerprint,
+		FirstSeen:   seen,
+		LastSeen:    seen,
+		Traces:      1,
+		Hops:        make([]routeHop, len(hops)),
+	}
+	for i, hop := range hops {
+		p.Hops[i] = routeHop{Hop: i, Addresses: []string{}}
+		if set := hop.responderSet(); set != "" {
+			p.Hops[i].Addresses = strings.Split(set, ",")
+		}
+		for _, r := range hop.Responders {
+			if len(r.MPLS) > 0 {
+				if p.Hops[i].MPLS == nil {
+					p.Hops[i].MPLS = make(map[string]string)
+				}
+				p.Hops[i].MPLS[r.IP.String()] = mplsStack(r.MPLS)
+			}
+		}
+	}
+	return p
+}
+
+// recordRoute adds the route of a trace to the history of the host and
+// updates mtr_route_info. A path is added whenever it differs from the
+// previous one, so a flapping route shows up as alternating paths. The
+// caller must hold e.mutex.
+func (e *Exporter) recordRoute(labels []string, hops []*Hop, seen time.Time) {
+	alias := labels[0]
+	fingerprint := routeFingerprint(hops)
+	history := e.routes[alias]
+	if n := len(history); n > 0 && history[n-1].Fingerprint == fingerprint {
+		history[n-1].LastSeen = seen
+		history[n-1].Traces++
+	} else {
+		if n > 0 {
+			e.series.remove(alias, append(labels, history[n-1].Fingerprint), e.routeInfo)
+		}
+		history = append(history, newRoutePath(fingerprint, hops, seen))
+		max := currentConfig().RouteHistory
+		if max == 0 {
+			max = defaultRouteHistory
+		}
+		if len(history) > max {
+			history = append([]*routePath{}, history[len(history)-max:]...)
+		}
+		e.routes[alias] = history
+	}
+
+	lvs := append(labels, fingerprint)
+	e.routeInfo.WithLabelValues(lvs...).Set(1)
+	e.series.add(alias, lvs, e.routeInfo)
+}
+
+// routesHandler returns the path history of a host as JSON on
+// /api/v1/routes/<alias>, the most recent path last
+func (e *Exporter) routesHandler(w http.ResponseWriter, r *http.Request) {
+	alias := strings.TrimPrefix(r.URL.Path, "/api/v1/routes/")
+
+	e.mutex.Lock()
+	_, ok := e.workers[alias]
+	paths := make([]routePath, 0, len(e.routes[alias]))
+	for _, p := range e.routes[alias] {
+		paths = append(paths, *p)
+	}
+	e.mutex.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown alias "+alias, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Alias string      `json:"alias"`
+		Paths []routePath `json:"paths"`
+	}{alias, paths})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deleter is implemented by all metric vectors
+type deleter interface {
+	DeleteLabelValues(lvs ...string) bool
+}
+
+type seriesKey struct {
+	vec    deleter
+	labels string
+}
+
+// seriesEntry is a series along with when it was last written
+type seriesEntry struct {
+	lvs       []string
+	lastSeen  time.Time
+	lastTrace int
+}
+
+// seriesTracker remembers the label values written per alias. The vendored
+// client library can only delete a series by its full set of label values, so
+// this is needed to remove all series of a target. It also remembers when each
+// series was last written, so series of hops or addresses that are gone can
+// be deleted.
+type seriesTracker struct {
+	mutex   sync.Mutex
+	byAlias map[string]map[seriesKey]*seriesEntry
+	// traces counts the traces per alias
+	traces map[string]int
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{
+		byAlias: make(map[string]map[seriesKey]*seriesEntry),
+		traces:  make(map[string]int),
+	}
+}
+
+// add records that the series with the label values exist in all vecs
+func (s *seriesTracker) add(alias string, lvs []string, vecs ...deleter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	series, ok := s.byAlias[alias]
+	if !ok {
+		series = make(map[seriesKey]*seriesEntry)
+		s.byAlias[alias] = series
+	}
+	labels := strings.Join(lvs, "\xff")
+	now := time.Now()
+	for _, vec := range vecs {
+		key := seriesKey{vec: vec, labels: labels}
+		entry, ok := series[key]
+		if !ok {
+			entry = &seriesEntry{lvs: append([]string{}, lvs...)}
+			series[key] = entry
+		}
+		entry.lastSeen = now
+		entry.lastTrace = s.traces[alias]
+	}
+}
+
+// has reports whether the series with the label values exists in any of vecs
+func (s *seriesTracker) has(alias string, lvs []string, vecs ...deleter) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	labels := strings.Join(lvs, "\xff")
+	for _, vec := range vecs {
+		if _, ok := s.byAlias[alias][seriesKey{vec: vec, labels: labels}]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// count returns the number of series of the alias. If vecs are given, it
+// returns the number of distinct label values in them instead.
+func (s *seriesTracker) count(alias string, vecs ...deleter) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(vecs) == 0 {
+		return len(s.byAlias[alias])
+	}
+	labels := make(map[string]bool)
+	for key := range s.byAlias[alias] {
+		for _, vec := range vecs {
+			if key.vec == vec {
+				labels[key.labels] = true
+			}
+		}
+	}
+	return len(labels)
+}
+
+// trace counts a trace of the alias, series written afterwards belong to it
+func (s *seriesTracker) trace(alias string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.traces[alias]++
+}
+
+// expire deletes the series of the alias in vecs that were not written for
+// longer than ttl or during the last traces traces, 0 disables either limit.
+// It returns the number of deleted series.
+func (s *seriesTracker) expire(alias string, ttl time.Duration, traces int, vecs ...deleter) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expirable := make(map[deleter]bool, len(vecs))
+	for _, vec := range vecs {
+		expirable[vec] = true
+	}
+	n := 0
+	for key, entry := range s.byAlias[alias] {
+		if !expirable[key.vec] {
+			continue
+		}
+		if (ttl > 0 && time.Since(entry.lastSeen) > ttl) || (traces > 0 && s.traces[alias]-entry.lastTrace >= traces) {
+			key.vec.DeleteLabelValues(entry.lvs...)
+			delete(s.byAlias[alias], key)
+			n++
+		}
+	}
+	return n
+}
+
+// labelValues returns the label values of all series of the alias in vec
+func (s *seriesTracker) labelValues(alias string, vec deleter) [][]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var lvs [][]string
+	for key, entry := range s.byAlias[alias] {
+		if key.vec == vec {
+			lvs = append(lvs, entry.lvs)
+		}
+	}
+	return lvs
+}
+
+// remove deletes the series with the label values from vec
+func (s *seriesTracker) remove(alias string, lvs []string, vec deleter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	vec.DeleteLabelValues(lvs...)
+	delete(s.byAlias[alias], seriesKey{vec: vec, labels: strings.Join(lvs, "\xff")})
+}
+
+// forget drops all series of vec without deleting them, for vecs that are
+// replaced as a whole
+func (s *seriesTracker) forget(vec deleter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, series := range s.byAlias {
+		for key := range series {
+			if key.vec == vec {
+				delete(series, key)
+			}
+		}
+	}
+}
+
+// delete removes all series recorded for the alias from their vecs
+func (s *seriesTracker) delete(alias string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, entry := range s.byAlias[alias] {
+		key.vec.DeleteLabelValues(entry.lvs...)
+	}
+	delete(s.byAlias, alias)
+	delete(s.traces, alias)
+}
+
+// traceGauges records the per hop gauges written for a single trace, so those
+// of the previous trace that were not written again can be deleted. Gauges
+// describe the last trace only and must not keep hops or addresses that
+// are gone.
+type traceGauges map[seriesKey][]string
+
+// set sets the gauge with the label values and records a copy of them, so
+// callers may append to a shared prefix
+func (g traceGauges) set(vec *prometheus.GaugeVec, value float64, lvs ...string) {
+	vec.WithLabelValues(lvs...).Set(value)
+	g[seriesKey{vec: vec, labels: strings.Join(lvs, "\xff")}] = append([]string{}, lvs...)
+}
+
+// deleteStale deletes the gauges of previous that were not set again, from
+// their vecs as well as from the series of the alias
+func (g traceGauges) deleteStale(previous traceGauges, series *seriesTracker, alias string) {
+	for key, lvs := range previous {
+		if _, ok := g[key]; !ok {
+			series.remove(alias, lvs, key.vec)
+		}
+	}
+}
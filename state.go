@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// stateVersion is the version of the state file format, files of other
+// versions are ignored
+const stateVersion = 1
+
+// destinationChange is the number of changes between two destination addresses
+type destinationChange struct {
+	Previous string  `json:"previous"`
+	Current  string  `json:"current"`
+	Count    float64 `json:"count"`
+}
+
+// targetState is the change detection state of a host as stored in the state
+// directory. It is only restored for a host with the same target, protocol
+// and port.
+type targetState struct {
+	Version            int                 `json:"version"`
+	Alias              string              `json:"alias"`
+	Target             string              `json:"target"`
+	Protocol           string              `json:"protocol"`
+	Port               string              `json:"port"`
+	LastRoute          []string            `json:"last_route"`
+	LastDestination    string              `json:"last_destination,omitempty"`
+	Routes             []*routePath        `json:"routes"`
+	RouteChanges       map[string]float64  `json:"route_changes"`
+	DestinationChanges []destinationChange `json:"destination_changes"`
+	LastASPath         string              `json:"last_as_path,omitempty"`
+	ASPathChanges      float64             `json:"as_path_changes,omitempty"`
+}
+
+// stateWrite is a pending write of the state of alias, a nil state removes the file
+type stateWrite struct {
+	alias string
+	state *targetState
+}
+
+// stateFile returns the path of the state file of the alias
+func stateFile(dir string, alias string) string {
+	return filepath.Join(dir, url.PathEscape(alias)+".json")
+}
+
+// counterValue returns the value of a counter
+func counterValue(c interface {
+	Write(*dto.Metric) error
+}) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil || m.Counter == nil {
+		return 0
+	}
+	return m.Counter.GetValue()
+}
+
+// snapshot returns the state of the alias. The caller must hold e.mutex.
+func (e *Exporter) snapshot(labels []string) *targetState {
+	alias := labels[0]
+	s := &targetState{
+		Version:      stateVersion,
+		Alias:        alias,
+		Target:       labels[1],
+		Protocol:     labels[2],
+		Port:         labels[3],
+		LastRoute:    e.lastRoute[alias],
+		Routes:       e.routes[alias],
+		RouteChanges: make(map[string]float64),
+		LastASPath:   e.lastASPath[alias],
+	}
+	if dest := e.lastDest[alias]; dest != nil {
+		s.LastDestination = dest.String()
+	}
+	for _, lvs := range e.series.labelValues(alias, e.routeChanges) {
+		s.RouteChanges[lvs[4]] = counterValue(e.routeChanges.WithLabelValues(lvs...))
+	}
+	for _, lvs := range e.series.labelValues(alias, e.destinationChanges) {
+		s.DestinationChanges = append(s.DestinationChanges, destinationChange{
+			Previous: lvs[4],
+			Current:  lvs[5],
+			Count:    counterValue(e.destinationChanges.WithLabelValues(lvs...)),
+		})
+	}
+
+	if len(e.series.labelValues(alias, e.asPathChanges)) > 0 {
+		s.ASPathChanges = counterValue(e.asPathChanges.WithLabelValues(labels...))
+	}
+
+	// the writer encodes the state later, copy what is modified in place
+	routes := make([]*routePath, len(s.Routes))
+	for i, p := range s.Routes {
+		c := *p
+		routes[i] = &c
+	}
+	s.Routes = routes
+	return s
+}
+
+// saveState queues a write of the state of the alias. The caller must hold e.mutex.
+func (e *Exporter) saveState(labels []string) {
+	if e.stateDir != "" {
+		e.stateWrites <- stateWrite{alias: labels[0], state: e.snapshot(labels)}
+	}
+}
+
+// removeState queues the removal of the state of the alias. The caller must hold e.mutex.
+func (e *Exporter) removeState(alias string) {
+	if e.stateDir != "" {
+		e.stateWrites <- stateWrite{alias: alias}
+	}
+}
+
+// writeStates writes the queued states to the state directory
+func (e *Exporter) writeStates() {
+	for w := range e.stateWrites {
+		file := stateFile(e.stateDir, w.alias)
+		if w.state == nil {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Errorf("Error removing state of %s: %s", w.alias, err)
+			}
+			continue
+		}
+		if err := writeFileAtomic(file, w.state); err != nil {
+			log.Errorf("Error saving state of %s: %s", w.alias, err)
+		}
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file and renames it to
+// file, so a crash never leaves a partially written file behind
+func writeFileAtomic(file string, v interface{}) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// readState reads a state file, it fails for files of other versions
+func readState(file string) (*targetState, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := &targetState{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported version %d", s.Version)
+	}
+	return s, nil
+}
+
+// loadState restores the state of all configured hosts from the state
+// directory. Missing, unreadable or corrupt files are skipped, the host then
+// starts without state.
+func (e *Exporter) loadState() error {
+	if err := os.MkdirAll(e.stateDir, 0755); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, host := range currentConfig().Hosts {
+		file := stateFile(e.stateDir, host.Alias)
+		s, err := readState(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Warnf("Ignoring state file %s: %s", file, err)
+			continue
+		}
+		labels := []string{host.Alias, host.Name, host.protocol(), host.portLabel()}
+		if s.Alias != labels[0] || s.Target != labels[1] || s.Protocol != labels[2] || s.Port != labels[3] {
+			log.Infof("Ignoring state file %s, the host changed", file)
+			continue
+		}
+		e.restore(labels, s)
+	}
+	return nil
+}
+
+// restore applies the state of a host. The caller must hold e.mutex.
+func (e *Exporter) restore(labels []string, s *targetState) {
+	alias := labels[0]
+	e.lastRoute[alias] = s.LastRoute
+	if s.LastASPath != "" {
+		e.lastASPath[alias] = s.LastASPath
+	}
+	if dest := net.ParseIP(s.LastDestination); dest != nil {
+		e.lastDest[alias] = dest
+	}
+	var routes []*routePath
+	for _, p := range s.Routes {
+		if p != nil {
+			routes = append(routes, p)
+		}
+	}
+	e.routes[alias] = routes
+
+	for hop, count := range s.RouteChanges {
+		if _, err := strconv.Atoi(hop); err != nil || count <= 0 {
+			continue
+		}
+		lvs := append(labels[:4:4], hop)
+		e.routeChanges.WithLabelValues(lvs...).Add(count)
+		e.series.add(alias, lvs, e.routeChanges)
+	}
+	for _, c := range s.DestinationChanges {
+		if c.Count <= 0 || strings.TrimSpace(c.Previous) == "" || strings.TrimSpace(c.Current) == "" {
+			continue
+		}
+		lvs := append(labels[:4:4], c.Previous, c.Current)
+		e.destinationChanges.WithLabelValues(lvs...).Add(c.Count)
+		e.series.add(alias, lvs, e.destinationChanges)
+	}
+	if s.ASPathChanges > 0 {
+		e.asPathChanges.WithLabelValues(labels...).Add(s.ASPathChanges)
+		e.series.add(alias, labels, e.asPathChanges)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// targetStatus is the outcome of the last trace of a target
+type targetStatus struct {
+	Alias    string
+	Target   string
+	Protocol string
+	Port     string
+	LastRun  time.Time
+	Duration time.Duration
+	Hops     int
+	Route    string
+	Reason   string
+	Error    string
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<html>
+            <head><title>MTR Exporter Status</title></head>
+            <body>
+            <h1>MTR Exporter Status</h1>
+            <table border="1" cellpadding="4">
+            <tr><th>Alias</th><th>Target</th><th>Protocol</th><th>Port</th><th>Last run</th><th>Duration</th><th>Hops</th><th>Route</th><th>Result</th><th>Last error</th></tr>
+            {{range .}}<tr>
+            <td>{{.Alias}}</td><td>{{.Target}}</td><td>{{.Protocol}}</td><td>{{.Port}}</td>
+            <td>{{if .LastRun.IsZero}}never{{else}}{{.LastRun.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+            <td>{{.Duration}}</td><td>{{.Hops}}</td>
+            <td><a href="/api/v1/routes/{{.Alias}}">{{if .Route}}{{.Route}}{{else}}history{{end}}</a></td>
+            <td>{{if .Reason}}failed ({{.Reason}}){{else if .LastRun.IsZero}}{{else}}ok{{end}}</td>
+            <td><pre>{{.Error}}</pre></td>
+            </tr>{{end}}
+            </table>
+            </body>
+            </html>`))
+
+// setStatus records the outcome of a trace. The caller must hold e.mutex.
+func (e *Exporter) setStatus(tf *TargetFeedback) {
+	s := &targetStatus{
+		Alias:    tf.Alias,
+		Target:   tf.Target,
+		Protocol: tf.Protocol,
+		Port:     tf.Port,
+		LastRun:  time.Now(),
+		Duration: tf.Duration,
+	}
+	if tf.Err != nil {
+		s.Reason = failureReason(tf.Err)
+		s.Error = tf.Err.Error()
+		// keep the hops of the last successful trace
+		if last, ok := e.status[tf.Alias]; ok {
+			s.Hops = last.Hops
+		}
+	} else {
+		s.Hops = len(tf.Result.Hops)
+	}
+	e.status[tf.Alias] = s
+}
+
+// statusHandler shows the outcome of the last trace of every target
+func (e *Exporter) statusHandler(w http.ResponseWriter, r *http.Request) {
+	e.mutex.Lock()
+	statuses := make([]targetStatus, 0, len(e.workers))
+	for alias, worker := range e.workers {
+		if s, ok := e.status[alias]; ok {
+			status := *s
+			if paths := e.routes[alias]; len(paths) > 0 {
+				status.Route = paths[len(paths)-1].Fingerprint
+			}
+			statuses = append(statuses, status)
+		} else {
+			statuses = append(statuses, targetStatus{
+				Alias:    alias,
+				Target:   worker.host.Name,
+				Protocol: worker.host.protocol(),
+				Port:     worker.host.portLabel(),
+			})
+		}
+	}
+	e.mutex.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Alias < statuses[j].Alias })
+	if err := statusTemplate.Execute(w, statuses); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}